@@ -0,0 +1,202 @@
+// Package accel describes Windows accelerator table resources (RT_ACCELERATOR).
+package accel
+
+// ACCELERATORS: https://docs.microsoft.com/en-us/windows/win32/menurc/acceltableentry
+// Virtual-Key codes: https://docs.microsoft.com/en-us/windows/win32/inputdev/virtual-key-codes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Flags of ACCELTABLEENTRY.
+const (
+	FVIRTKEY  = 0x01
+	FNOINVERT = 0x02
+	FSHIFT    = 0x04
+	FCONTROL  = 0x08
+	FALT      = 0x10
+	FLAST     = 0x80 // marks the last entry of a table
+)
+
+type ACCELTABLEENTRY struct {
+	Flags   uint16 // fVirt
+	Key     uint16 // ASCII character, or virtual-key code if FVIRTKEY is set
+	Cmd     uint16 // command ID sent in WM_COMMAND
+	Padding uint16 // must be 0
+}
+
+// Table is the contents of a single RT_ACCELERATOR resource.
+type Table []ACCELTABLEENTRY
+
+func (t Table) Size() int64 {
+	return int64(len(t) * binary.Size(ACCELTABLEENTRY{}))
+}
+
+// Accel describes a single keyboard shortcut, with Key in format accepted by
+// ParseKey.
+type Accel struct {
+	Key string
+	Cmd uint16
+}
+
+// Build converts accels to a table, setting FLAST on the final entry.
+func Build(accels []Accel) (Table, error) {
+	if len(accels) == 0 {
+		return nil, fmt.Errorf("accel: empty accelerator table")
+	}
+	t := make(Table, 0, len(accels))
+	for _, a := range accels {
+		flags, key, err := ParseKey(a.Key)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, ACCELTABLEENTRY{Flags: flags, Key: key, Cmd: a.Cmd})
+	}
+	t[len(t)-1].Flags |= FLAST
+	return t, nil
+}
+
+// Parse reads an accelerator table from text, where each line contains a key
+// specification and a command ID, separated by whitespace, e.g.:
+//
+//	Ctrl+Shift+S  100
+//	VK_F5         101
+//
+// Empty lines and lines starting with '#' are ignored.
+func Parse(r io.Reader) (Table, error) {
+	var accels []Accel
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("accel: line %d: expected key and command ID, got %q", line, text)
+		}
+		cmd, err := strconv.ParseUint(fields[1], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("accel: line %d: bad command ID %q: %s", line, fields[1], err)
+		}
+		accels = append(accels, Accel{Key: fields[0], Cmd: uint16(cmd)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Build(accels)
+}
+
+// ParseKey converts a key specification like "Ctrl+Shift+S", "Alt+F4" or
+// "VK_F5" to flags and key of an ACCELTABLEENTRY. Modifier and key names are
+// case-insensitive. A single character without modifiers is stored as an
+// ASCII key; anything else becomes a virtual key.
+func ParseKey(spec string) (flags uint16, key uint16, err error) {
+	parts := strings.Split(spec, "+")
+	// allow "Ctrl++" to mean Ctrl with the plus key
+	if len(parts) > 1 && parts[len(parts)-1] == "" && parts[len(parts)-2] == "" {
+		parts = append(parts[:len(parts)-2], "+")
+	}
+	for _, mod := range parts[:len(parts)-1] {
+		switch strings.ToUpper(mod) {
+		case "CTRL", "CONTROL":
+			flags |= FCONTROL
+		case "SHIFT":
+			flags |= FSHIFT
+		case "ALT":
+			flags |= FALT
+		default:
+			return 0, 0, fmt.Errorf("accel: unknown modifier %q in key %q", mod, spec)
+		}
+	}
+
+	name := parts[len(parts)-1]
+	if len(name) == 1 && flags == 0 {
+		return 0, uint16(name[0]), nil
+	}
+	vk, ok := virtualKey(name)
+	if !ok {
+		return 0, 0, fmt.Errorf("accel: unknown key %q in %q", name, spec)
+	}
+	return flags | FVIRTKEY, vk, nil
+}
+
+func virtualKey(name string) (uint16, bool) {
+	upper := strings.TrimPrefix(strings.ToUpper(name), "VK_")
+	if len(upper) == 1 {
+		c := upper[0]
+		if 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			return uint16(c), true // VK codes of letters and digits match ASCII
+		}
+	}
+	if len(upper) >= 2 && upper[0] == 'F' {
+		n, err := strconv.Atoi(upper[1:])
+		if err == nil && 1 <= n && n <= 24 {
+			return uint16(0x70 + n - 1), true // VK_F1..VK_F24
+		}
+	}
+	vk, ok := virtualKeys[upper]
+	return vk, ok
+}
+
+var virtualKeys = map[string]uint16{
+	"BACK":       0x08,
+	"BACKSPACE":  0x08,
+	"TAB":        0x09,
+	"CLEAR":      0x0C,
+	"RETURN":     0x0D,
+	"ENTER":      0x0D,
+	"PAUSE":      0x13,
+	"ESCAPE":     0x1B,
+	"ESC":        0x1B,
+	"SPACE":      0x20,
+	"PRIOR":      0x21,
+	"PGUP":       0x21,
+	"PAGEUP":     0x21,
+	"NEXT":       0x22,
+	"PGDN":       0x22,
+	"PAGEDOWN":   0x22,
+	"END":        0x23,
+	"HOME":       0x24,
+	"LEFT":       0x25,
+	"UP":         0x26,
+	"RIGHT":      0x27,
+	"DOWN":       0x28,
+	"SNAPSHOT":   0x2C,
+	"INSERT":     0x2D,
+	"INS":        0x2D,
+	"DELETE":     0x2E,
+	"DEL":        0x2E,
+	"HELP":       0x2F,
+	"NUMPAD0":    0x60,
+	"NUMPAD1":    0x61,
+	"NUMPAD2":    0x62,
+	"NUMPAD3":    0x63,
+	"NUMPAD4":    0x64,
+	"NUMPAD5":    0x65,
+	"NUMPAD6":    0x66,
+	"NUMPAD7":    0x67,
+	"NUMPAD8":    0x68,
+	"NUMPAD9":    0x69,
+	"MULTIPLY":   0x6A,
+	"ADD":        0x6B,
+	"SEPARATOR":  0x6C,
+	"SUBTRACT":   0x6D,
+	"DECIMAL":    0x6E,
+	"DIVIDE":     0x6F,
+	"+":          0xBB, // VK_OEM_PLUS
+	"PLUS":       0xBB,
+	"OEM_PLUS":   0xBB,
+	",":          0xBC,
+	"OEM_COMMA":  0xBC,
+	"-":          0xBD,
+	"MINUS":      0xBD,
+	"OEM_MINUS":  0xBD,
+	".":          0xBE,
+	"OEM_PERIOD": 0xBE,
+}
@@ -0,0 +1,61 @@
+package accel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		spec  string
+		flags uint16
+		key   uint16
+	}{
+		{"a", 0, 'a'},
+		{"Ctrl+Shift+S", FVIRTKEY | FCONTROL | FSHIFT, 'S'},
+		{"ctrl+s", FVIRTKEY | FCONTROL, 'S'},
+		{"VK_F5", FVIRTKEY, 0x74},
+		{"Alt+F4", FVIRTKEY | FALT, 0x73},
+		{"Ctrl++", FVIRTKEY | FCONTROL, 0xBB},
+		{"Shift+VK_DELETE", FVIRTKEY | FSHIFT, 0x2E},
+	}
+	for _, tt := range tests {
+		flags, key, err := ParseKey(tt.spec)
+		if err != nil {
+			t.Errorf("ParseKey(%q): %s", tt.spec, err)
+			continue
+		}
+		if flags != tt.flags || key != tt.key {
+			t.Errorf("ParseKey(%q) = %#x, %#x; want %#x, %#x", tt.spec, flags, key, tt.flags, tt.key)
+		}
+	}
+
+	for _, spec := range []string{"", "Hyper+S", "VK_NOSUCHKEY", "F25"} {
+		_, _, err := ParseKey(spec)
+		if err == nil {
+			t.Errorf("ParseKey(%q): expected error", spec)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	table, err := Parse(strings.NewReader("# comment\nCtrl+S 100\n\nVK_F5 0x65\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Table{
+		{Flags: FVIRTKEY | FCONTROL, Key: 'S', Cmd: 100},
+		{Flags: FVIRTKEY | FLAST, Key: 0x74, Cmd: 0x65},
+	}
+	if len(table) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(table), len(want))
+	}
+	for i := range want {
+		if table[i] != want[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, table[i], want[i])
+		}
+	}
+	if table.Size() != 16 {
+		t.Errorf("Size() = %d, want 16", table.Size())
+	}
+}
@@ -1,7 +1,10 @@
 package binutil
 
 import (
+	"bytes"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"os"
 )
 
@@ -11,25 +14,52 @@ type SizedReader interface {
 }
 
 type SizedFile struct {
-	f *os.File
+	f fs.File
 	s *io.SectionReader // helper, for Size()
 }
 
-func (r *SizedFile) Read(p []byte) (n int, err error) { return r.s.Read(p) }
-func (r *SizedFile) Size() int64                      { return r.s.Size() }
-func (r *SizedFile) Close() error                     { return r.f.Close() }
+func (r *SizedFile) Read(p []byte) (n int, err error)              { return r.s.Read(p) }
+func (r *SizedFile) ReadAt(p []byte, off int64) (n int, err error) { return r.s.ReadAt(p, off) }
+func (r *SizedFile) Size() int64                                   { return r.s.Size() }
+func (r *SizedFile) Close() error                                  { return r.f.Close() }
 
 func SizedOpen(filename string) (*SizedFile, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	return NewSizedFile(f)
+}
+
+// SizedOpenFS opens file name in fsys, like SizedOpen.
+func SizedOpenFS(fsys fs.FS, name string) (*SizedFile, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewSizedFile(f)
+}
+
+// NewSizedFile wraps an open file f, using its Stat for Size. Closing the
+// returned SizedFile closes f, also on error. If f does not implement
+// io.ReaderAt, its contents are read into memory.
+func NewSizedFile(f fs.File) (*SizedFile, error) {
 	info, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
 	return &SizedFile{
 		f: f,
-		s: io.NewSectionReader(f, 0, info.Size()),
+		s: io.NewSectionReader(ra, 0, info.Size()),
 	}, nil
 }
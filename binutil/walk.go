@@ -45,11 +45,14 @@ func walk(v reflect.Value, spath string, walker Walker) error {
 			return err
 		}
 	case reflect.Struct:
-		//t := v.Type()
+		t := v.Type()
 		for i := 0; i < v.NumField(); i++ {
-			//f := t.Field(i) //TODO: handle unexported fields
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported fields are not part of binary data
+			}
 			vv := v.Field(i)
-			err = walk(vv, path.Join(spath, v.Type().Field(i).Name), walker)
+			err = walk(vv, path.Join(spath, f.Name), walker)
 			if stopping(err) {
 				return err
 			}
@@ -1,9 +1,9 @@
 package binutil
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
-	"reflect"
 )
 
 type Writer struct {
@@ -16,11 +16,14 @@ func (w *Writer) WriteLE(v interface{}) {
 	if w.Err != nil {
 		return
 	}
-	w.Err = binary.Write(w.W, binary.LittleEndian, v)
+	buf := &bytes.Buffer{}
+	w.Err = binary.Write(buf, binary.LittleEndian, v)
 	if w.Err != nil {
 		return
 	}
-	w.Offset += uint32(reflect.TypeOf(v).Size())
+	var n int
+	n, w.Err = w.W.Write(buf.Bytes())
+	w.Offset += uint32(n)
 }
 
 func (w *Writer) WriteFromSized(r SizedReader) {
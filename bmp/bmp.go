@@ -0,0 +1,99 @@
+// Package bmp describes Windows BMP file format, and conversion of images to
+// device-independent bitmaps (DIBs) as stored in RT_BITMAP resources.
+package bmp
+
+// BMP/DIB: http://msdn.microsoft.com/en-us/library/windows/desktop/dd183562%28v=vs.85%29.aspx
+// RT_BITMAP: https://devblogs.microsoft.com/oldnewthing/20091211-00/?p=15693
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/akavel/rsrc/ico"
+)
+
+type BITMAPFILEHEADER struct {
+	Type      uint16 // must be 'BM'
+	Size      uint32 // size of the whole file
+	Reserved1 uint16
+	Reserved2 uint16
+	OffBits   uint32 // offset of pixel data, from beginning of file
+}
+
+const magic = 'B' | 'M'<<8
+
+// DecodeFileHeader reads and verifies the BITMAPFILEHEADER of a .bmp file. The
+// rest of the file is a DIB, suitable for storing as a RT_BITMAP resource.
+func DecodeFileHeader(r io.Reader) (*BITMAPFILEHEADER, error) {
+	var hdr BITMAPFILEHEADER
+	err := binary.Read(r, binary.LittleEndian, &hdr)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Type != magic {
+		return nil, fmt.Errorf("bad magic number")
+	}
+	return &hdr, nil
+}
+
+// FromImage converts img to a bottom-up, uncompressed DIB. Images with any
+// transparent pixels are stored as 32 bits per pixel with alpha, others with
+// 24 bits per pixel.
+func FromImage(img image.Image) []byte {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	bpp := 24
+	if !opaque(img) {
+		bpp = 32
+	}
+	stride := (w*bpp/8 + 3) &^ 3 // rows are padded to 4 bytes
+
+	hdr := ico.BITMAPINFOHEADER{
+		Size:        uint32(binary.Size(ico.BITMAPINFOHEADER{})),
+		Width:       int32(w),
+		Height:      int32(h), // positive, i.e. bottom-up
+		Planes:      1,
+		BitCount:    uint16(bpp),
+		Compression: ico.BI_RGB,
+		SizeImage:   uint32(stride * h),
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, int(hdr.Size)+stride*h))
+	binary.Write(buf, binary.LittleEndian, hdr)
+
+	row := make([]byte, stride)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		p := row
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// NOTE: RGBA() returns alpha-premultiplied values, which is
+			// what AlphaBlend expects in 32 bpp bitmaps
+			r, g, b, a := img.At(x, y).RGBA()
+			p[0], p[1], p[2] = byte(b>>8), byte(g>>8), byte(r>>8)
+			if bpp == 32 {
+				p[3] = byte(a >> 8)
+			}
+			p = p[bpp/8:]
+		}
+		buf.Write(row)
+	}
+	return buf.Bytes()
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			if a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/akavel/rsrc/ico"
+)
+
+func TestFromImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff})
+	img.Set(0, 1, color.NRGBA{R: 0x40, G: 0x50, B: 0x60, A: 0xff})
+
+	dib := FromImage(img)
+	var hdr ico.BITMAPINFOHEADER
+	err := binary.Read(bytes.NewReader(dib), binary.LittleEndian, &hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Width != 3 || hdr.Height != 2 || hdr.BitCount != 32 || hdr.SizeImage != 3*4*2 {
+		t.Fatalf("unexpected header: %+v", hdr)
+	}
+	pixels := dib[hdr.Size:]
+	if len(pixels) != int(hdr.SizeImage) {
+		t.Fatalf("got %d bytes of pixels, want %d", len(pixels), hdr.SizeImage)
+	}
+	// bottom-up: the last row of the image goes first, as BGRA
+	if want := []byte{0x60, 0x50, 0x40, 0xff}; !bytes.Equal(pixels[:4], want) {
+		t.Errorf("first pixel: got % x, want % x", pixels[:4], want)
+	}
+	if want := []byte{0x30, 0x20, 0x10, 0xff}; !bytes.Equal(pixels[12:16], want) {
+		t.Errorf("first pixel of second row: got % x, want % x", pixels[12:16], want)
+	}
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+	dib = FromImage(opaque)
+	binary.Read(bytes.NewReader(dib), binary.LittleEndian, &hdr)
+	if hdr.BitCount != 24 || hdr.SizeImage != 12 { // 9 bytes padded to 4
+		t.Fatalf("unexpected header for opaque image: %+v", hdr)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"debug/pe"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf16"
 
 	"github.com/akavel/rsrc/binutil"
 )
@@ -23,6 +25,80 @@ type Dir struct { // struct IMAGE_RESOURCE_DIRECTORY
 	NumberOfIdEntries    uint16
 	DirEntries
 	Dirs
+
+	names []string // for named entries, at the same index as in DirEntries
+}
+
+// search finds the entry identified by name, or by id if name is empty. If
+// not found, returns the index where the entry should be inserted to keep
+// entries sorted, with named entries preceding ID entries. Names are sorted
+// by UTF-16 code units, like Windows does.
+func (dir *Dir) search(name string, id uint32) (int, bool) {
+	named := int(dir.NumberOfNamedEntries)
+	if name != "" {
+		u := utf16.Encode([]rune(name))
+		i := sort.Search(named, func(i int) bool {
+			return compareUTF16(utf16.Encode([]rune(dir.names[i])), u) >= 0
+		})
+		return i, i < named && dir.names[i] == name
+	}
+	i := named + sort.Search(len(dir.DirEntries)-named, func(i int) bool {
+		return dir.DirEntries[named+i].NameOrId >= id
+	})
+	return i, i < len(dir.DirEntries) && dir.DirEntries[i].NameOrId == id
+}
+
+// insert inserts an entry with an empty subdirectory at index i.
+func (dir *Dir) insert(i int, name string, id uint32) {
+	dir.fillNames()
+	dir.DirEntries = append(dir.DirEntries[:i], append([]DirEntry{{NameOrId: id}}, dir.DirEntries[i:]...)...)
+	dir.Dirs = append(dir.Dirs[:i], append([]Dir{{}}, dir.Dirs[i:]...)...)
+	dir.names = append(dir.names[:i], append([]string{name}, dir.names[i:]...)...)
+	if name != "" {
+		dir.NumberOfNamedEntries++
+	} else {
+		dir.NumberOfIdEntries++
+	}
+}
+
+// remove removes the entry at index i, together with its subdirectory.
+func (dir *Dir) remove(i int) {
+	dir.fillNames()
+	if dir.names[i] != "" {
+		dir.NumberOfNamedEntries--
+	} else {
+		dir.NumberOfIdEntries--
+	}
+	dir.DirEntries = append(dir.DirEntries[:i], dir.DirEntries[i+1:]...)
+	dir.Dirs = append(dir.Dirs[:i], dir.Dirs[i+1:]...)
+	dir.names = append(dir.names[:i], dir.names[i+1:]...)
+}
+
+// fillNames extends dir.names to the length of dir.DirEntries.
+func (dir *Dir) fillNames() {
+	for len(dir.names) < len(dir.DirEntries) {
+		dir.names = append(dir.names, "")
+	}
+}
+
+// leaves returns the number of leaf entries in dir's subtree of given depth.
+func (dir *Dir) leaves(depth int) int {
+	if depth == 0 {
+		return len(dir.DirEntries)
+	}
+	n := 0
+	for i := range dir.Dirs {
+		n += dir.Dirs[i].leaves(depth - 1)
+	}
+	return n
+}
+
+// name returns the name of i-th entry of dir, or "" if the entry has an ID.
+func (dir *Dir) name(i int) string {
+	if i < len(dir.names) {
+		return dir.names[i]
+	}
+	return ""
 }
 
 type DirEntries []DirEntry
@@ -33,6 +109,16 @@ type DirEntry struct { // struct IMAGE_RESOURCE_DIRECTORY_ENTRY
 	OffsetToData uint32
 }
 
+type DirString struct { // struct IMAGE_RESOURCE_DIR_STRING_U
+	Length     uint16
+	NameString []uint16
+}
+
+func newDirString(s string) DirString {
+	u := utf16.Encode([]rune(s))
+	return DirString{Length: uint16(len(u)), NameString: u}
+}
+
 type DataEntry struct { // struct IMAGE_RESOURCE_DATA_ENTRY
 	OffsetToData uint32
 	Size1        uint32
@@ -74,10 +160,17 @@ type StringsHeader struct {
 
 const (
 	MASK_SUBDIRECTORY = 1 << 31
-
-	RT_ICON       = 3
-	RT_GROUP_ICON = 3 + 11
-	RT_MANIFEST   = 24
+	MASK_NAME         = 1 << 31
+
+	RT_BITMAP       = 2
+	RT_ICON         = 3
+	RT_ACCELERATOR  = 9
+	RT_RCDATA       = 10
+	RT_MESSAGETABLE = 11
+	RT_GROUP_ICON   = 3 + 11
+	RT_VERSION      = 16
+	RT_HTML         = 23
+	RT_MANIFEST     = 24
 )
 
 // http://www.delorie.com/djgpp/doc/coff/symtab.html
@@ -106,8 +199,10 @@ type Coff struct {
 	pe.SectionHeader32
 
 	*Dir
-	DataEntries []DataEntry
-	Data        []PaddedData
+	DataEntries       []DataEntry
+	DirStrings        []DirString // names of named entries in Dir, filled in Freeze
+	DirStringsPadding []byte
+	Data              []PaddedData
 
 	Relocations []RelocationEntry
 	Symbols     []Symbol
@@ -181,6 +276,8 @@ func NewRSRC() *Coff {
 		&Dir{},
 
 		[]DataEntry{},
+		[]DirString{},
+		[]byte{},
 		[]PaddedData{},
 
 		[]RelocationEntry{},
@@ -201,9 +298,58 @@ func NewRSRC() *Coff {
 	}
 }
 
-//NOTE: function assumes that 'id' is increasing on each entry
+// AddResource adds a resource in the default language (see LANG_ENTRY).
+// Resources may be added in any order; adding the same kind and id twice
+// (in the same language) is an error.
+//NOTE: only usable for Coff created using NewRSRC
+func (coff *Coff) AddResource(kind uint32, id uint16, data Sizer) error {
+	return coff.AddResourceLang(kind, id, uint16(LANG_ENTRY.NameOrId), data)
+}
+
+// AddResourceLang adds a resource in the specified language. The same 'id'
+// may be added multiple times with different languages.
 //NOTE: only usable for Coff created using NewRSRC
-func (coff *Coff) AddResource(kind uint32, id uint16, data Sizer) {
+func (coff *Coff) AddResourceLang(kind uint32, id uint16, lang uint16, data Sizer) error {
+	return coff.addResource(kind, "", id, lang, data)
+}
+
+// AddNamedResource adds a resource identified by a string name instead of
+// an ID. Names are case-sensitive; Windows expects them in uppercase.
+//NOTE: only usable for Coff created using NewRSRC
+func (coff *Coff) AddNamedResource(kind uint32, name string, data Sizer) error {
+	return coff.AddNamedResourceLang(kind, name, uint16(LANG_ENTRY.NameOrId), data)
+}
+
+// AddNamedResourceLang adds a named resource in the specified language.
+//NOTE: only usable for Coff created using NewRSRC
+func (coff *Coff) AddNamedResourceLang(kind uint32, name string, lang uint16, data Sizer) error {
+	return coff.addResource(kind, name, 0, lang, data)
+}
+
+func (coff *Coff) addResource(kind uint32, name string, id uint16, lang uint16, data Sizer) error {
+	// find entries at each level, inserting new if necessary at correct sorted position
+	dir0 := coff.Dir
+	i0, found := dir0.search("", kind)
+	if !found {
+		dir0.insert(i0, "", kind)
+	}
+	dir1 := &dir0.Dirs[i0]
+	i1, found := dir1.search(name, uint32(id))
+	if !found {
+		dir1.insert(i1, name, uint32(id))
+	}
+	dir2 := &dir1.Dirs[i1]
+	i2, found := dir2.search("", uint32(lang))
+	if found {
+		return fmt.Errorf("coff: duplicate resource: %s", resourceKey(kind, name, id, lang))
+	}
+	dir2.insert(i2, "", uint32(lang))
+
+	// insert new data in correct place
+	n := coff.leafIndex(i0, i1, i2)
+	coff.DataEntries = append(coff.DataEntries[:n], append([]DataEntry{{Size1: uint32(data.Size())}}, coff.DataEntries[n:]...)...)
+	coff.Data = append(coff.Data[:n], append([]PaddedData{pad(data)}, coff.Data[n:]...)...)
+
 	re := RelocationEntry{
 		// "(zero based) index in the Symbol table to which the
 		// reference refers.  Once you have loaded the COFF file into
@@ -212,52 +358,188 @@ func (coff *Coff) AddResource(kind uint32, id uint16, data Sizer) {
 		// reference accordingly."
 		SymbolIndex: 0,
 	}
-	switch coff.Machine {
+	re.Type, _ = relocationType(coff.Machine)
+	coff.Relocations = append(coff.Relocations, re)
+	coff.SectionHeader32.NumberOfRelocations++
+	return nil
+}
+
+// relocationType returns the type of relocations of DataEntries, pointing at
+// an address relative to the image base, for machine.
+func relocationType(machine uint16) (uint16, bool) {
+	switch machine {
 	case pe.IMAGE_FILE_MACHINE_I386:
-		re.Type = _IMAGE_REL_I386_DIR32NB
+		return _IMAGE_REL_I386_DIR32NB, true
 	case pe.IMAGE_FILE_MACHINE_AMD64:
-		re.Type = _IMAGE_REL_AMD64_ADDR32NB
+		return _IMAGE_REL_AMD64_ADDR32NB, true
 	case pe.IMAGE_FILE_MACHINE_ARMNT:
-		re.Type = _IMAGE_REL_ARM_ADDR32NB
+		return _IMAGE_REL_ARM_ADDR32NB, true
 	case pe.IMAGE_FILE_MACHINE_ARM64:
-		re.Type = _IMAGE_REL_ARM64_ADDR32NB
+		return _IMAGE_REL_ARM64_ADDR32NB, true
 	}
-	coff.Relocations = append(coff.Relocations, re)
-	coff.SectionHeader32.NumberOfRelocations++
+	return 0, false
+}
 
-	// find top level entry, inserting new if necessary at correct sorted position
-	entries0 := coff.Dir.DirEntries
-	dirs0 := coff.Dir.Dirs
-	i0 := sort.Search(len(entries0), func(i int) bool {
-		return entries0[i].NameOrId >= kind
-	})
-	if i0 >= len(entries0) || entries0[i0].NameOrId != kind {
-		// inserting new entry & dir
-		entries0 = append(entries0[:i0], append([]DirEntry{{NameOrId: kind}}, entries0[i0:]...)...)
-		dirs0 = append(dirs0[:i0], append([]Dir{{}}, dirs0[i0:]...)...)
-		coff.Dir.NumberOfIdEntries++
-	}
-	coff.Dir.DirEntries = entries0
-	coff.Dir.Dirs = dirs0
-
-	// for second level, assume ID is always increasing, so we don't have to sort
-	dirs0[i0].DirEntries = append(dirs0[i0].DirEntries, DirEntry{NameOrId: uint32(id)})
-	dirs0[i0].Dirs = append(dirs0[i0].Dirs, Dir{
-		NumberOfIdEntries: 1,
-		DirEntries:        DirEntries{LANG_ENTRY},
-	})
-	dirs0[i0].NumberOfIdEntries++
+// resourceKey describes a resource in error messages.
+func resourceKey(kind uint32, name string, id uint16, lang uint16) string {
+	return Resource{Type: kind, Name: name, ID: id, Lang: lang}.String()
+}
 
-	// calculate preceding DirEntry leaves, to find new index in Data & DataEntries
+// leafIndex returns the index in DataEntries of the language entry i2 of
+// entry i1 of type i0.
+func (coff *Coff) leafIndex(i0, i1, i2 int) int {
 	n := 0
-	for _, dir0 := range dirs0[:i0+1] {
-		n += len(dir0.DirEntries) //NOTE: assuming 1 language here; TODO: dwell deeper if more langs added
+	for i := 0; i < i0; i++ {
+		n += coff.Dir.Dirs[i].leaves(1)
+	}
+	dir1 := &coff.Dir.Dirs[i0]
+	for i := 0; i < i1; i++ {
+		n += dir1.Dirs[i].leaves(0)
 	}
-	n--
+	return n + i2
+}
 
-	// insert new data in correct place
-	coff.DataEntries = append(coff.DataEntries[:n], append([]DataEntry{{Size1: uint32(data.Size())}}, coff.DataEntries[n:]...)...)
-	coff.Data = append(coff.Data[:n], append([]PaddedData{pad(data)}, coff.Data[n:]...)...)
+// SetTimeDateStamp sets the time stamp of the file header and of all the
+// directories of the .rsrc tree, as seconds since the Unix epoch. Directories
+// created by resources added later get a zero time stamp.
+func (coff *Coff) SetTimeDateStamp(t uint32) {
+	coff.FileHeader.TimeDateStamp = t
+	coff.Dir.TimeDateStamp = t
+	for i := range coff.Dir.Dirs { // resource type
+		dir1 := &coff.Dir.Dirs[i]
+		dir1.TimeDateStamp = t
+		for j := range dir1.Dirs { // resource ID
+			dir1.Dirs[j].TimeDateStamp = t
+		}
+	}
+}
+
+// Resource describes a single resource stored in a Coff, i.e. a leaf of the
+// .rsrc directory tree. Named resources have Name set, and ID equal 0.
+//
+// TypeName is only set for resources of named types (e.g. "MUI"), which
+// can be read from existing files, but not added to a Coff.
+type Resource struct {
+	Type     uint32
+	TypeName string
+	ID       uint16
+	Name     string
+	Lang     uint16
+	Data     Sizer
+}
+
+func (r Resource) String() string {
+	kind := fmt.Sprintf("type %d", r.Type)
+	if r.TypeName != "" {
+		kind = fmt.Sprintf("type '%s'", r.TypeName)
+	}
+	if r.Name != "" {
+		return fmt.Sprintf("%s, name '%s', language 0x%04X", kind, r.Name, r.Lang)
+	}
+	return fmt.Sprintf("%s, ID %d, language 0x%04X", kind, r.ID, r.Lang)
+}
+
+// VisitResources calls fn for every resource in coff, in the order in which
+// they are stored: sorted by type, then name or ID, then language. If fn
+// returns an error, the iteration is stopped and the error is returned. fn
+// must not add or remove resources.
+func (coff *Coff) VisitResources(fn func(r Resource) error) error {
+	n := 0
+	for i0, e0 := range coff.Dir.DirEntries {
+		dir1 := &coff.Dir.Dirs[i0]
+		for i1, e1 := range dir1.DirEntries {
+			dir2 := &dir1.Dirs[i1]
+			for _, e2 := range dir2.DirEntries {
+				r := Resource{
+					Type: e0.NameOrId,
+					Name: dir1.name(i1),
+					Lang: uint16(e2.NameOrId),
+					Data: coff.Data[n].Data,
+				}
+				if r.Name == "" {
+					r.ID = uint16(e1.NameOrId)
+				}
+				n++
+				err := fn(r)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Resources returns a list of all resources in coff, in the order described
+// in VisitResources.
+func (coff *Coff) Resources() []Resource {
+	resources := []Resource{}
+	coff.VisitResources(func(r Resource) error {
+		resources = append(resources, r)
+		return nil
+	})
+	return resources
+}
+
+// find returns location of the resource identified by r.Type, r.Name (or r.ID
+// if r.Name is empty) and r.Lang, at each level of Dir, and the index of its
+// data.
+func (coff *Coff) find(r Resource) (i0, i1, i2, n int, err error) {
+	notFound := fmt.Errorf("coff: resource not found: %s", r)
+	i0, found := coff.Dir.search("", r.Type)
+	if !found || r.TypeName != "" {
+		return 0, 0, 0, 0, notFound
+	}
+	dir1 := &coff.Dir.Dirs[i0]
+	i1, found = dir1.search(r.Name, uint32(r.ID))
+	if !found {
+		return 0, 0, 0, 0, notFound
+	}
+	i2, found = dir1.Dirs[i1].search("", uint32(r.Lang))
+	if !found {
+		return 0, 0, 0, 0, notFound
+	}
+	return i0, i1, i2, coff.leafIndex(i0, i1, i2), nil
+}
+
+// ReplaceResource replaces the data of an existing resource identified by
+// r.Type, r.Name (or r.ID if r.Name is empty) and r.Lang, with r.Data.
+//NOTE: Freeze must be called again before writing coff
+func (coff *Coff) ReplaceResource(r Resource) error {
+	_, _, _, n, err := coff.find(r)
+	if err != nil {
+		return err
+	}
+	coff.DataEntries[n].Size1 = uint32(r.Data.Size())
+	coff.Data[n] = pad(r.Data)
+	return nil
+}
+
+// RemoveResource removes the resource identified by r.Type, r.Name (or r.ID
+// if r.Name is empty) and r.Lang; r.Data is ignored. Directory entries left
+// without any resources are removed as well.
+//NOTE: Freeze must be called again before writing coff
+func (coff *Coff) RemoveResource(r Resource) error {
+	i0, i1, i2, n, err := coff.find(r)
+	if err != nil {
+		return err
+	}
+	dir1 := &coff.Dir.Dirs[i0]
+	dir2 := &dir1.Dirs[i1]
+	dir2.remove(i2)
+	if len(dir2.DirEntries) == 0 {
+		dir1.remove(i1)
+	}
+	if len(dir1.DirEntries) == 0 {
+		coff.Dir.remove(i0)
+	}
+
+	coff.DataEntries = append(coff.DataEntries[:n], coff.DataEntries[n+1:]...)
+	coff.Data = append(coff.Data[:n], coff.Data[n+1:]...)
+	// all relocations are identical, each pointing at one of DataEntries
+	coff.Relocations = coff.Relocations[:len(coff.Relocations)-1]
+	coff.SectionHeader32.NumberOfRelocations--
+	return nil
 }
 
 func pad(data Sizer) PaddedData {
@@ -267,6 +549,28 @@ func pad(data Sizer) PaddedData {
 	}
 }
 
+// WriteTo writes coff to w, returning the number of bytes written. Freeze
+// must be called first.
+func (coff *Coff) WriteTo(w io.Writer) (int64, error) {
+	bw := binutil.Writer{W: w}
+	binutil.Walk(coff, func(v reflect.Value, path string) error {
+		if binutil.Plain(v.Kind()) {
+			bw.WriteLE(v.Interface())
+			return nil
+		}
+		vv, ok := v.Interface().(binutil.SizedReader)
+		if ok {
+			bw.WriteFromSized(vv)
+			return binutil.WALK_SKIP
+		}
+		return nil
+	})
+	if bw.Err != nil {
+		return int64(bw.Offset), fmt.Errorf("coff: error writing output: %w", bw.Err)
+	}
+	return int64(bw.Offset), nil
+}
+
 // Freeze fills in some important offsets in resulting file.
 func (coff *Coff) Freeze() {
 	switch coff.SectionHeader32.Name {
@@ -302,7 +606,59 @@ func freezeCommon2(v reflect.Value, offset *uint32) error {
 	return nil
 }
 
+// collectDirStrings fills coff.DirStrings with all names used in coff.Dir,
+// returning the index of each name in DirStrings.
+func (coff *Coff) collectDirStrings() map[string]int {
+	indexes := map[string]int{}
+	coff.DirStrings = coff.DirStrings[:0]
+	size := 0
+	var collect func(dir *Dir)
+	collect = func(dir *Dir) {
+		for _, name := range dir.names {
+			if _, ok := indexes[name]; name == "" || ok {
+				continue
+			}
+			indexes[name] = len(coff.DirStrings)
+			s := newDirString(name)
+			coff.DirStrings = append(coff.DirStrings, s)
+			size += binary.Size(s.Length) + 2*len(s.NameString)
+		}
+		for i := range dir.Dirs {
+			collect(&dir.Dirs[i])
+		}
+	}
+	collect(coff.Dir)
+	coff.DirStringsPadding = make([]byte, -size&7)
+	return indexes
+}
+
+// patchNames sets NameOrId of named entries to offsets of their DirStrings.
+func (dir *Dir) patchNames(indexes map[string]int, offsets []uint32) {
+	for i := range dir.DirEntries {
+		if name := dir.name(i); name != "" {
+			dir.DirEntries[i].NameOrId = MASK_NAME | offsets[indexes[name]]
+		}
+	}
+	for i := range dir.Dirs {
+		dir.Dirs[i].patchNames(indexes, offsets)
+	}
+}
+
+// Paths of fields patched by freezeRSRC, compiled once, as they are matched
+// against every field of the file.
+var (
+	reTypeDir         = regexp.MustCompile(`^/Dir/Dirs\[(\d+)\]$`)
+	reNameDir         = regexp.MustCompile(`^/Dir/Dirs\[(\d+)\]/Dirs\[(\d+)\]$`)
+	reDataEntry       = regexp.MustCompile(`^/DataEntries\[(\d+)\]$`)
+	reDataEntryOffset = regexp.MustCompile(`^/DataEntries\[(\d+)\]/OffsetToData$`)
+	reDirString       = regexp.MustCompile(`^/DirStrings\[(\d+)\]$`)
+	reData            = regexp.MustCompile(`^/Data\[(\d+)\]$`)
+)
+
 func (coff *Coff) freezeRSRC() {
+	names := coff.collectDirStrings()
+	nameoffsets := make([]uint32, len(coff.DirStrings))
+
 	leafwalker := make(chan *DirEntry)
 	go func() {
 		for _, dir1 := range coff.Dir.Dirs { // resource type
@@ -318,25 +674,26 @@ func (coff *Coff) freezeRSRC() {
 	binutil.Walk(coff, func(v reflect.Value, path string) error {
 		diroff = coff.freezeCommon1(path, offset, diroff)
 
-		RE := regexp.MustCompile
-		const N = `\[(\d+)\]`
 		m := matcher{}
 		switch {
-		case m.Find(path, RE("^/Dir/Dirs"+N+"$")):
+		case m.Find(path, reTypeDir):
 			coff.Dir.DirEntries[m[0]].OffsetToData = MASK_SUBDIRECTORY | (offset - diroff)
-		case m.Find(path, RE("^/Dir/Dirs"+N+"/Dirs"+N+"$")):
+		case m.Find(path, reNameDir):
 			coff.Dir.Dirs[m[0]].DirEntries[m[1]].OffsetToData = MASK_SUBDIRECTORY | (offset - diroff)
-		case m.Find(path, RE("^/DataEntries"+N+"$")):
+		case m.Find(path, reDataEntry):
 			direntry := <-leafwalker
 			direntry.OffsetToData = offset - diroff
-		case m.Find(path, RE("^/DataEntries"+N+"/OffsetToData$")):
+		case m.Find(path, reDataEntryOffset):
 			coff.Relocations[m[0]].RVA = offset - diroff
-		case m.Find(path, RE("^/Data"+N+"$")):
+		case m.Find(path, reDirString):
+			nameoffsets[m[0]] = offset - diroff
+		case m.Find(path, reData):
 			coff.DataEntries[m[0]].OffsetToData = offset - diroff
 		}
 
 		return freezeCommon2(v, &offset)
 	})
+	coff.Dir.patchNames(names, nameoffsets)
 }
 
 func mustAtoi(s string) int {
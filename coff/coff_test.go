@@ -0,0 +1,330 @@
+package coff_test
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/internal"
+)
+
+type leaf struct {
+	path string // e.g. "14/2/1033" or "23/NAME/1033"
+	data string
+}
+
+func TestAddResourceAnyOrder(t *testing.T) {
+	out := coff.NewRSRC()
+	if err := out.Arch("amd64"); err != nil {
+		t.Fatal(err)
+	}
+	add := []struct {
+		kind uint32
+		id   uint16
+		name string
+		lang uint16
+	}{
+		{coff.RT_MANIFEST, 1, "", 0x409},
+		{coff.RT_ICON, 7, "", 0x409},
+		{coff.RT_ICON, 2, "", 0x409},
+		{coff.RT_HTML, 0, "B.HTML", 0x409},
+		{coff.RT_ICON, 5, "", 0x415},
+		{coff.RT_ICON, 5, "", 0x409},
+		{coff.RT_HTML, 3, "", 0x409},
+		{coff.RT_HTML, 0, "A.HTML", 0x409},
+		{coff.RT_GROUP_ICON, 4, "", 0x409},
+		// in UTF-8, "！" (U+FF01) sorts before "😀" (U+1F600), but not in
+		// UTF-16, which is used by Windows
+		{coff.RT_HTML, 0, "！", 0x409},
+		{coff.RT_HTML, 0, "😀", 0x409},
+	}
+	for _, a := range add {
+		data := strings.NewReader(fmt.Sprintf("%d/%d/%s/%d", a.kind, a.id, a.name, a.lang))
+		var err error
+		switch {
+		case a.name != "":
+			err = out.AddNamedResource(a.kind, a.name, data)
+		default:
+			err = out.AddResourceLang(a.kind, a.id, a.lang, data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := out.AddResourceLang(coff.RT_ICON, 5, 0x415, strings.NewReader("dup"))
+	if err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected error about duplicate resource, got: %v", err)
+	}
+	err = out.AddNamedResource(coff.RT_HTML, "A.HTML", strings.NewReader("dup"))
+	if err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected error about duplicate named resource, got: %v", err)
+	}
+
+	// checked by coff.Verify too
+	got := writeAndParse(t, out)
+	want := []string{
+		"3/2/1033",
+		"3/5/1033",
+		"3/5/1045",
+		"3/7/1033",
+		"14/4/1033",
+		"23/A.HTML/1033",
+		"23/B.HTML/1033",
+		"23/😀/1033",
+		"23/！/1033",
+		"23/3/1033",
+		"24/1/1033",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d leaves, want %d: %v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].path != w {
+			t.Errorf("leaf %d: got %s, want %s", i, got[i].path, w)
+		}
+		// data of a leaf must match its position in the tree
+		parts := strings.Split(w, "/")
+		id, name := parts[1], ""
+		if id[0] < '0' || id[0] > '9' {
+			id, name = "0", id
+		}
+		if wantData := fmt.Sprintf("%s/%s/%s/%s", parts[0], id, name, parts[2]); got[i].data != wantData {
+			t.Errorf("leaf %s: got data %q, want %q", w, got[i].data, wantData)
+		}
+	}
+}
+
+func TestRemoveReplaceResources(t *testing.T) {
+	out := coff.NewRSRC()
+	if err := out.Arch("386"); err != nil {
+		t.Fatal(err)
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(out.AddResource(coff.RT_ICON, 1, strings.NewReader("icon1")))
+	must(out.AddResource(coff.RT_ICON, 2, strings.NewReader("icon2")))
+	must(out.AddResourceLang(coff.RT_ICON, 2, 0x415, strings.NewReader("icon2pl")))
+	must(out.AddNamedResource(coff.RT_HTML, "INDEX.HTML", strings.NewReader("html")))
+	must(out.AddResource(coff.RT_MANIFEST, 1, strings.NewReader("manifest")))
+
+	var listed []string
+	for _, r := range out.Resources() {
+		listed = append(listed, r.String())
+	}
+	wantListed := []string{
+		"type 3, ID 1, language 0x0409",
+		"type 3, ID 2, language 0x0409",
+		"type 3, ID 2, language 0x0415",
+		"type 23, name 'INDEX.HTML', language 0x0409",
+		"type 24, ID 1, language 0x0409",
+	}
+	if strings.Join(listed, "\n") != strings.Join(wantListed, "\n") {
+		t.Errorf("got resources:\n%s\nwant:\n%s", strings.Join(listed, "\n"), strings.Join(wantListed, "\n"))
+	}
+
+	must(out.ReplaceResource(coff.Resource{Type: coff.RT_ICON, ID: 2, Lang: 0x409, Data: strings.NewReader("new icon2")}))
+	// removing the only resource of a name, and of a type, must drop the empty directories
+	must(out.RemoveResource(coff.Resource{Type: coff.RT_HTML, Name: "INDEX.HTML", Lang: 0x409}))
+	must(out.RemoveResource(coff.Resource{Type: coff.RT_ICON, ID: 1, Lang: 0x409}))
+	must(out.RemoveResource(coff.Resource{Type: coff.RT_ICON, ID: 2, Lang: 0x415}))
+
+	err := out.RemoveResource(coff.Resource{Type: coff.RT_ICON, ID: 1, Lang: 0x409})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected error about missing resource, got: %v", err)
+	}
+	err = out.ReplaceResource(coff.Resource{Type: coff.RT_HTML, Name: "INDEX.HTML", Lang: 0x409, Data: strings.NewReader("x")})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected error about missing resource, got: %v", err)
+	}
+
+	got := writeAndParse(t, out)
+	want := []leaf{
+		{"3/2/1033", "new icon2"},
+		{"24/1/1033", "manifest"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got leaves %v, want %v", got, want)
+	}
+	if n := out.SectionHeader32.NumberOfRelocations; n != 2 {
+		t.Errorf("got NumberOfRelocations=%d, want 2", n)
+	}
+	if n := out.Dir.NumberOfIdEntries; n != 2 {
+		t.Errorf("got %d resource types, want 2", n)
+	}
+}
+
+type failingWriter struct{ n int }
+
+var errWrite = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n < len(p) {
+		n := w.n
+		w.n = 0
+		return n, errWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	newCoff := func() *coff.Coff {
+		out := coff.NewRSRC()
+		if err := out.AddResource(coff.RT_MANIFEST, 1, strings.NewReader("<assembly/>")); err != nil {
+			t.Fatal(err)
+		}
+		out.Freeze()
+		return out
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := newCoff().WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+	}
+
+	n, err = newCoff().WriteTo(&failingWriter{n: 30})
+	if !errors.Is(err, errWrite) {
+		t.Errorf("expected error wrapping %v, got: %v", errWrite, err)
+	}
+	if n != 30 {
+		t.Errorf("WriteTo returned %d, but wrote 30 bytes", n)
+	}
+}
+
+func TestSetTimeDateStamp(t *testing.T) {
+	out := coff.NewRSRC()
+	for _, kind := range []uint32{coff.RT_MANIFEST, coff.RT_RCDATA} {
+		if err := out.AddResource(kind, 1, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.SetTimeDateStamp(0x12345678)
+	out.Freeze()
+	buf := &bytes.Buffer{}
+	if _, err := out.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	f, err := pe.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.TimeDateStamp != 0x12345678 {
+		t.Errorf("got file header time stamp 0x%x", f.TimeDateStamp)
+	}
+	section, err := f.Section(".rsrc").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// root, 2 types and 2 IDs
+	if n := bytes.Count(section, []byte{0x78, 0x56, 0x34, 0x12}); n != 5 {
+		t.Errorf("got time stamp in %d directories, want 5", n)
+	}
+}
+
+// writeAndParse writes out to a temporary file and decodes leaves of the
+// resource tree from it, verifying that entries are sorted at each level and
+// that coff.Verify finds no problems.
+func writeAndParse(t *testing.T, out *coff.Coff) []leaf {
+	out.Freeze()
+	dir, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "out.syso")
+	err = internal.Write(out, fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := coff.Verify(data); problems != nil {
+		t.Errorf("problems found in output:\n%s", strings.Join(problems, "\n"))
+	}
+
+	f, err := pe.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	section, err := f.Section(".rsrc").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.Section(".rsrc").Relocs); n != len(out.DataEntries) {
+		t.Errorf("got %d relocations, want %d", n, len(out.DataEntries))
+	}
+
+	var leaves []leaf
+	var parse func(offset uint32, path string, depth int)
+	parse = func(offset uint32, path string, depth int) {
+		var hdr struct {
+			Characteristics, TimeDateStamp uint32
+			MajorVersion, MinorVersion     uint16
+			NumberOfNamedEntries           uint16
+			NumberOfIdEntries              uint16
+		}
+		r := bytes.NewReader(section[offset:])
+		binary.Read(r, binary.LittleEndian, &hdr)
+		entries := make([]coff.DirEntry, hdr.NumberOfNamedEntries+hdr.NumberOfIdEntries)
+		binary.Read(r, binary.LittleEndian, entries)
+
+		for i, e := range entries {
+			var key string
+			if e.NameOrId&coff.MASK_NAME != 0 {
+				if i >= int(hdr.NumberOfNamedEntries) {
+					t.Errorf("%s: named entry %d after ID entries", path, i)
+				}
+				off := e.NameOrId &^ coff.MASK_NAME
+				n := binary.LittleEndian.Uint16(section[off:])
+				u := make([]uint16, n)
+				binary.Read(bytes.NewReader(section[off+2:]), binary.LittleEndian, u)
+				// order of names, by UTF-16 code units, is checked by
+				// coff.Verify
+				key = string(utf16.Decode(u))
+			} else {
+				key = fmt.Sprint(e.NameOrId)
+				if i > int(hdr.NumberOfNamedEntries) && e.NameOrId <= entries[i-1].NameOrId {
+					t.Errorf("%s: IDs not sorted: %d after %d", path, e.NameOrId, entries[i-1].NameOrId)
+				}
+			}
+			subpath := strings.TrimPrefix(path+"/"+key, "/")
+			if depth < 2 {
+				if e.OffsetToData&coff.MASK_SUBDIRECTORY == 0 {
+					t.Fatalf("%s: expected subdirectory", subpath)
+				}
+				parse(e.OffsetToData&^coff.MASK_SUBDIRECTORY, subpath, depth+1)
+				continue
+			}
+			var data coff.DataEntry
+			binary.Read(bytes.NewReader(section[e.OffsetToData:]), binary.LittleEndian, &data)
+			if data.OffsetToData%8 != 0 {
+				t.Errorf("%s: data not aligned: offset %d", subpath, data.OffsetToData)
+			}
+			leaves = append(leaves, leaf{subpath, string(section[data.OffsetToData : data.OffsetToData+data.Size1])})
+		}
+	}
+	parse(0, "", 0)
+	return leaves
+}
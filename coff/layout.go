@@ -0,0 +1,152 @@
+package coff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/akavel/rsrc/binutil"
+)
+
+// Field describes a piece of a COFF file, as written by WriteTo.
+type Field struct {
+	Offset uint32 // from the start of the file
+	Size   uint32
+	Path   string // as passed by binutil.Walk, e.g. "/Dir/DirEntries[1]/OffsetToData"
+	Value  string // hex for numbers, quoted for strings and (beginning of) data
+}
+
+// maxPreview is the number of bytes of data shown in Field.Value.
+const maxPreview = 16
+
+// Layout lists all the fields of coff in order of their offsets in the file,
+// like a linker map. Byte and UTF-16 arrays, as well as contents of
+// resources and strings, are listed as single fields; empty ones are
+// skipped. Freeze must be called first.
+func (coff *Coff) Layout() []Field {
+	return appendFields(nil, 0, "", coff)
+}
+
+// appendFields appends to fields the fields of value, which starts at offset
+// in the file, with paths as passed by binutil.Walk prefixed by prefix.
+func appendFields(fields []Field, offset uint32, prefix string, value interface{}) []Field {
+	add := func(path string, size uint32, value string) {
+		if size > 0 {
+			fields = append(fields, Field{Offset: offset, Size: size, Path: path, Value: value})
+		}
+		offset += size
+	}
+	binutil.Walk(value, func(v reflect.Value, path string) error {
+		path = joinPath(prefix, path)
+		if binutil.Plain(v.Kind()) {
+			size := uint32(binary.Size(v.Interface()))
+			add(path, size, fmt.Sprintf("0x%0*x", 2*size, v.Interface()))
+			return nil
+		}
+		if sz, ok := v.Interface().(Sizer); ok {
+			add(path, uint32(sz.Size()), preview(sz))
+			return binutil.WALK_SKIP
+		}
+		switch v.Kind() {
+		case reflect.Array, reflect.Slice:
+			switch v.Type().Elem().Kind() {
+			case reflect.Uint8:
+				b := make([]byte, v.Len())
+				reflect.Copy(reflect.ValueOf(b), v)
+				add(path, uint32(len(b)), quote(b))
+				return binutil.WALK_SKIP
+			case reflect.Uint16:
+				u := make([]uint16, v.Len())
+				reflect.Copy(reflect.ValueOf(u), v)
+				add(path, uint32(2*len(u)), fmt.Sprintf("%q", string(utf16.Decode(u))))
+				return binutil.WALK_SKIP
+			}
+		}
+		return nil
+	})
+	return fields
+}
+
+// joinPath appends path, as passed by binutil.Walk, to prefix, so that e.g.
+// "/Relocations" and "/[1]/RVA" give "/Relocations[1]/RVA".
+func joinPath(prefix, path string) string {
+	rest := strings.TrimPrefix(path, "/")
+	if rest == "" || strings.HasPrefix(rest, "[") {
+		return prefix + rest
+	}
+	return prefix + "/" + rest
+}
+
+// ReadLayout lists the fields of a COFF object file with resources, such as a
+// .syso file written by another tool or rejected by the linker, like Layout
+// does for files of this package, and with the same paths where they apply.
+// As much of data is read as possible; problems found on the way are
+// returned as by Verify. Section headers other than the only one are listed
+// as "/SectionHeaders[i]", and bytes not covered by any field as "(unused)".
+func ReadLayout(data []byte) ([]Field, []string) {
+	v := newVerifier(data)
+	v.verify()
+	fields := v.fields
+	// names are numbered in order of their offsets, as laid out by Freeze
+	offsets := make([]uint32, 0, len(v.names))
+	for off := range v.names {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	var namesEnd uint64
+	for i, off := range offsets {
+		name := DirString{Length: uint16(len(v.names[off])), NameString: v.names[off]}
+		fields = appendFields(fields, uint32(v.sectionStart)+off, fmt.Sprintf("/DirStrings[%d]", i), name)
+		namesEnd = uint64(off) + 2 + 2*uint64(len(name.NameString))
+	}
+	if pad := -namesEnd & 7; len(offsets) > 0 && namesEnd+pad <= uint64(len(v.section)) {
+		fields = appendFields(fields, uint32(v.sectionStart+namesEnd), "/DirStringsPadding", v.section[namesEnd:namesEnd+pad])
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Offset < fields[j].Offset })
+	var all []Field
+	var end uint32
+	unused := func(offset uint32) {
+		if offset > end {
+			all = append(all, Field{Offset: end, Size: offset - end, Path: "(unused)", Value: preview(bytes.NewReader(data[end:offset]))})
+		}
+	}
+	for _, f := range fields {
+		unused(f.Offset)
+		all = append(all, f)
+		if f.Offset+f.Size > end {
+			end = f.Offset + f.Size
+		}
+	}
+	unused(uint32(len(data)))
+	return all, v.problems
+}
+
+// preview returns the quoted beginning of data, if it can be read without
+// consuming it.
+func preview(data Sizer) string {
+	ra, ok := data.(io.ReaderAt)
+	if !ok {
+		return ""
+	}
+	n := data.Size()
+	if n > maxPreview {
+		n = maxPreview
+	}
+	b := make([]byte, n)
+	n2, _ := ra.ReadAt(b, 0)
+	s := quote(b[:n2])
+	if int64(n2) < data.Size() {
+		s += "..."
+	}
+	return s
+}
+
+func quote(b []byte) string {
+	return fmt.Sprintf("%+q", b)
+}
@@ -0,0 +1,112 @@
+package coff_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+func TestLayout(t *testing.T) {
+	out := coff.NewRSRC()
+	if err := out.Arch("amd64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.AddResource(coff.RT_MANIFEST, 1, strings.NewReader("<assembly/>")); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.AddNamedResource(coff.RT_HTML, "INDEX.HTML", strings.NewReader("<html>")); err != nil {
+		t.Fatal(err)
+	}
+	out.Freeze()
+	fields := out.Layout()
+	buf := &bytes.Buffer{}
+	if _, err := out.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	byPath := map[string]coff.Field{}
+	var end uint32
+	for _, f := range fields {
+		if f.Offset != end {
+			t.Errorf("%s at offset 0x%x, expected 0x%x", f.Path, f.Offset, end)
+		}
+		end = f.Offset + f.Size
+		byPath[f.Path] = f
+	}
+	if int(end) != buf.Len() {
+		t.Errorf("fields end at 0x%x, but file has 0x%x bytes", end, buf.Len())
+	}
+
+	want := []coff.Field{
+		{Offset: 0, Size: 2, Path: "/FileHeader/Machine", Value: "0x8664"},
+		{Offset: 20, Size: 8, Path: "/SectionHeader32/Name", Value: `".rsrc\x00\x00\x00"`},
+		{Offset: out.PointerToRawData + 12, Size: 2, Path: "/Dir/NumberOfNamedEntries", Value: "0x0000"},
+		{Offset: out.PointerToRawData + 16, Size: 4, Path: "/Dir/DirEntries[0]/NameOrId", Value: "0x00000017"},
+		{Offset: byPath["/DirStrings[0]/Length"].Offset + 2, Size: 20, Path: "/DirStrings[0]/NameString", Value: `"INDEX.HTML"`},
+		{Offset: byPath["/Data[1]/Data"].Offset + 11, Size: 5, Path: "/Data[1]/Padding", Value: `"\x00\x00\x00\x00\x00"`},
+	}
+	for _, w := range want {
+		if got := byPath[w.Path]; got != w {
+			t.Errorf("got %+v, want %+v", got, w)
+		}
+	}
+	data := byPath["/Data[1]/Data"]
+	if got := buf.Bytes()[data.Offset : data.Offset+data.Size]; string(got) != "<assembly/>" || data.Value != `"<assembly/>"` {
+		t.Errorf("got %+v, with %q at its offset in the file", data, got)
+	}
+}
+
+func TestReadLayout(t *testing.T) {
+	out := newVerifyCoff(t, "amd64")
+	buf := &bytes.Buffer{}
+	if _, err := out.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]coff.Field{}
+	for _, f := range out.Layout() {
+		want[f.Path] = f
+	}
+	fields, problems := coff.ReadLayout(buf.Bytes())
+	if problems != nil {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+	got := map[string]bool{}
+	for _, f := range fields {
+		got[f.Path] = true
+		if f.Path == "(unused)" {
+			continue
+		}
+		if f != want[f.Path] {
+			t.Errorf("got %+v, want %+v", f, want[f.Path])
+		}
+	}
+	// empty directories of leaves are written, but not pointed at
+	leafDir := regexp.MustCompile(`^/Dir(/Dirs\[\d+\]){3}/`)
+	for path := range want {
+		if !got[path] && !leafDir.MatchString(path) {
+			t.Errorf("%s not found", path)
+		}
+	}
+
+	// broken files are read as far as possible, and all their bytes listed
+	for _, size := range []int{10, 100, buf.Len() - 100} {
+		data := buf.Bytes()[:size]
+		fields, problems := coff.ReadLayout(data)
+		if problems == nil {
+			t.Errorf("file truncated to %d bytes: no problems found", size)
+		}
+		var end uint32
+		for _, f := range fields {
+			if f.Offset != end {
+				t.Errorf("file truncated to %d bytes: %s at offset 0x%x, expected 0x%x", size, f.Path, f.Offset, end)
+			}
+			end = f.Offset + f.Size
+		}
+		if int(end) != size {
+			t.Errorf("file truncated to %d bytes: fields end at 0x%x", size, end)
+		}
+	}
+}
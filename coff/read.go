@@ -0,0 +1,181 @@
+package coff
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// ReadRSRC reads the resources stored in the .rsrc section of a COFF object
+// file, such as a .syso file written by this package, or in the resource
+// directory of a PE image (an .exe or .dll file). Data of the returned
+// resources is a *bytes.Reader.
+func ReadRSRC(r io.ReaderAt) ([]Resource, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("coff: error reading COFF file: %s", err)
+	}
+	if f.OptionalHeader != nil {
+		return readImageRSRC(f)
+	}
+	section := f.Section(".rsrc")
+	if section == nil {
+		return nil, fmt.Errorf("coff: no .rsrc section found")
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("coff: error reading .rsrc section: %s", err)
+	}
+	return ParseRSRC(data, 0)
+}
+
+// readImageRSRC reads resources from the section of a PE image holding its
+// resource directory, as pointed to by the optional header.
+func readImageRSRC(f *pe.File) ([]Resource, error) {
+	var n uint32
+	var dir pe.DataDirectory
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		n, dir = h.NumberOfRvaAndSizes, h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+	case *pe.OptionalHeader64:
+		n, dir = h.NumberOfRvaAndSizes, h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+	}
+	if n <= pe.IMAGE_DIRECTORY_ENTRY_RESOURCE || dir.VirtualAddress == 0 {
+		return nil, nil // no resources
+	}
+	rva := dir.VirtualAddress
+	for _, s := range f.Sections {
+		if rva < s.VirtualAddress || rva-s.VirtualAddress >= s.Size {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("coff: error reading section %s: %s", s.Name, err)
+		}
+		return ParseRSRC(data[rva-s.VirtualAddress:], rva)
+	}
+	return nil, fmt.Errorf("coff: resource directory at 0x%x outside of sections", rva)
+}
+
+// ParseRSRC decodes the resource directory tree of a .rsrc section loaded at
+// relative virtual address rva; in COFF object files, offsets of data are
+// relative to the start of the section, so rva is 0. Data of the returned
+// resources is a *bytes.Reader, in the order of the tree.
+func ParseRSRC(section []byte, rva uint32) ([]Resource, error) {
+	p := &rsrcParser{section: section, rva: rva, visited: map[uint32]bool{}}
+	err := p.dir(0, 0, Resource{})
+	if err != nil {
+		return nil, err
+	}
+	return p.resources, nil
+}
+
+type rsrcParser struct {
+	section   []byte
+	rva       uint32
+	resources []Resource
+	visited   map[uint32]bool // offsets of directories
+}
+
+func (p *rsrcParser) read(offset uint32, v interface{}) error {
+	size := uint32(binary.Size(v))
+	if uint64(offset)+uint64(size) > uint64(len(p.section)) {
+		return fmt.Errorf("coff: %T at offset 0x%x outside of .rsrc section of size 0x%x", v, offset, len(p.section))
+	}
+	return binary.Read(bytes.NewReader(p.section[offset:offset+size]), binary.LittleEndian, v)
+}
+
+// dir decodes the directory at offset, of given depth in the tree (0 for
+// types, 1 for names and IDs, 2 for languages). Fields of r identify the
+// directory.
+func (p *rsrcParser) dir(offset uint32, depth int, r Resource) error {
+	// a directory shared by many entries could make a small, hostile file
+	// list a huge number of resources
+	if p.visited[offset] {
+		return fmt.Errorf("coff: directory at 0x%x referenced more than once", offset)
+	}
+	p.visited[offset] = true
+	var hdr struct {
+		Characteristics      uint32
+		TimeDateStamp        uint32
+		MajorVersion         uint16
+		MinorVersion         uint16
+		NumberOfNamedEntries uint16
+		NumberOfIdEntries    uint16
+	}
+	err := p.read(offset, &hdr)
+	if err != nil {
+		return err
+	}
+	entries := make([]DirEntry, int(hdr.NumberOfNamedEntries)+int(hdr.NumberOfIdEntries))
+	err = p.read(offset+uint32(binary.Size(hdr)), entries)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		r := r
+		switch {
+		case depth == 0 && e.NameOrId&MASK_NAME != 0:
+			r.TypeName, err = p.name(e.NameOrId &^ MASK_NAME)
+			if err != nil {
+				return err
+			}
+		case depth == 0:
+			r.Type = e.NameOrId
+		case depth == 1 && e.NameOrId&MASK_NAME != 0:
+			r.Name, err = p.name(e.NameOrId &^ MASK_NAME)
+			if err != nil {
+				return err
+			}
+		case depth == 1:
+			r.ID = uint16(e.NameOrId)
+		default:
+			r.Lang = uint16(e.NameOrId)
+		}
+		sub := e.OffsetToData&MASK_SUBDIRECTORY != 0
+		if sub != (depth < 2) {
+			return fmt.Errorf("coff: bad resource directory entry for %s, at depth %d", r, depth)
+		}
+		if sub {
+			err = p.dir(e.OffsetToData&^MASK_SUBDIRECTORY, depth+1, r)
+		} else {
+			err = p.leaf(e.OffsetToData, r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *rsrcParser) name(offset uint32) (string, error) {
+	var n uint16
+	err := p.read(offset, &n)
+	if err != nil {
+		return "", err
+	}
+	u := make([]uint16, n)
+	err = p.read(offset+2, u)
+	if err != nil {
+		return "", err
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+func (p *rsrcParser) leaf(offset uint32, r Resource) error {
+	var entry DataEntry
+	err := p.read(offset, &entry)
+	if err != nil {
+		return err
+	}
+	start := uint64(entry.OffsetToData) - uint64(p.rva)
+	if entry.OffsetToData < p.rva || start+uint64(entry.Size1) > uint64(len(p.section)) {
+		return fmt.Errorf("coff: data of %s at 0x%x, of size 0x%x, outside of .rsrc section", r, entry.OffsetToData, entry.Size1)
+	}
+	r.Data = bytes.NewReader(p.section[start : start+uint64(entry.Size1)])
+	p.resources = append(p.resources, r)
+	return nil
+}
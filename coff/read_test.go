@@ -0,0 +1,93 @@
+package coff_test
+
+import (
+	"bytes"
+	"debug/pe"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/internal/testutil"
+)
+
+func TestReadRSRC(t *testing.T) {
+	out := coff.NewRSRC()
+	if err := out.Arch("amd64"); err != nil {
+		t.Fatal(err)
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(out.AddResourceLang(coff.RT_ICON, 2, 0x0409, strings.NewReader("icon")))
+	must(out.AddResourceLang(coff.RT_MANIFEST, 1, 0x0409, strings.NewReader("<assembly/>")))
+	must(out.AddNamedResource(coff.RT_HTML, "INDEX.HTML", strings.NewReader("<html/>")))
+	must(out.AddResourceLang(coff.RT_MESSAGETABLE, 1, 0x0415, strings.NewReader("pl")))
+	must(out.AddResourceLang(coff.RT_MESSAGETABLE, 1, 0x0409, strings.NewReader("en")))
+	out.Freeze()
+	buf := &bytes.Buffer{}
+	if _, err := out.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := coff.ReadRSRC(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range resources {
+		data, _ := ioutil.ReadAll(r.Data.(*bytes.Reader))
+		got = append(got, r.String()+": "+string(data))
+	}
+	want := []string{
+		"type 3, ID 2, language 0x0409: icon",
+		"type 11, ID 1, language 0x0409: en",
+		"type 11, ID 1, language 0x0415: pl",
+		"type 23, name 'INDEX.HTML', language 0x0409: <html/>",
+		"type 24, ID 1, language 0x0409: <assembly/>",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got resources:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	f, err := pe.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	section, err := f.Section(".rsrc").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{8, 40, len(section) - 8} {
+		if _, err := coff.ParseRSRC(section[:n], 0); err == nil || !strings.Contains(err.Error(), "outside of .rsrc section") {
+			t.Errorf("section truncated to %d bytes: expected error, got: %v", n, err)
+		}
+	}
+
+	// make both types point at the subdirectory of the first one
+	shared := append([]byte{}, section...)
+	copy(shared[16+12:16+16], shared[16+4:16+8])
+	if _, err := coff.ParseRSRC(shared, 0); err == nil || !strings.Contains(err.Error(), "referenced more than once") {
+		t.Errorf("expected error for shared directory, got: %v", err)
+	}
+}
+
+func FuzzReadRSRC(f *testing.F) {
+	for _, arch := range []string{"386", "amd64"} {
+		buf := &bytes.Buffer{}
+		if _, err := newVerifyCoff(f, arch).WriteTo(buf); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resources, err := coff.ReadRSRC(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		testutil.CheckDataSizes(t, resources, len(data))
+	})
+}
@@ -0,0 +1,353 @@
+package coff
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Verify checks a COFF object file with resources, such as a .syso file
+// written by this package or by windres, for invariants which Windows and the
+// Go linker rely on. It returns descriptions of all the problems found, or
+// nil if there are none.
+//
+// Checked are: bounds of headers, sections, relocations, the symbol table and
+// the string table; entries of resource directories, which must be sorted,
+// unique, and have named entries before ID entries; bounds of directories,
+// names and data inside SizeOfRawData of the .rsrc section; alignment of
+// directories and DataEntries to 4 bytes, and of data to 8 bytes; and
+// relocations, of which there must be exactly one for each DataEntry,
+// pointing at its OffsetToData and relative to the start of the section.
+func Verify(data []byte) []string {
+	v := newVerifier(data)
+	v.verify()
+	return v.problems
+}
+
+func newVerifier(data []byte) *verifier {
+	return &verifier{file: data, visited: map[uint32]string{}, relocated: map[uint32]int{}, names: map[uint32][]uint16{}}
+}
+
+type verifier struct {
+	file         []byte
+	section      []byte // raw data of the .rsrc section
+	sectionStart uint64 // offset of section in file
+	problems     []string
+	fields       []Field             // read so far, for ReadLayout
+	names        map[uint32][]uint16 // names of entries, by offset in section
+
+	visited   map[uint32]string // "directory" or "DataEntry", by offset in section
+	leaves    []uint32          // offsets of DataEntries in section, in order of the tree
+	relocated map[uint32]int    // numbers of relocations at offsets in section
+}
+
+// record adds fields of value, read from offset in the file, for ReadLayout.
+func (v *verifier) record(offset uint64, path string, value interface{}) {
+	v.fields = appendFields(v.fields, uint32(offset), path, value)
+}
+
+func (v *verifier) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// read decodes v from data at offset, reporting a problem if it does not fit.
+func (v *verifier) read(data []byte, offset uint64, what string, value interface{}) bool {
+	size := uint64(binary.Size(value))
+	if !v.fits(data, offset, size, what) {
+		return false
+	}
+	binary.Read(bytes.NewReader(data[offset:offset+size]), binary.LittleEndian, value)
+	return true
+}
+
+// fits reports a problem if size bytes at offset exceed data. Counts read from
+// the file must be checked with it before allocating anything of their size.
+func (v *verifier) fits(data []byte, offset, size uint64, what string) bool {
+	if offset+size > uint64(len(data)) {
+		v.problem("%s at offset 0x%x, of size 0x%x, exceeds %d bytes", what, offset, size, len(data))
+		return false
+	}
+	return true
+}
+
+func (v *verifier) verify() {
+	var hdr pe.FileHeader
+	if !v.read(v.file, 0, "file header", &hdr) {
+		return
+	}
+	v.record(0, "/FileHeader", hdr)
+	reloctype, ok := relocationType(hdr.Machine)
+	if !ok {
+		v.problem("unknown machine type 0x%04x", hdr.Machine)
+	}
+	if hdr.SizeOfOptionalHeader != 0 {
+		v.problem("optional header of %d bytes found, expected none in object files", hdr.SizeOfOptionalHeader)
+	}
+	sections := make([]pe.SectionHeader32, hdr.NumberOfSections)
+	offset := uint64(binary.Size(hdr)) + uint64(hdr.SizeOfOptionalHeader)
+	if !v.read(v.file, offset, "section headers", sections) {
+		return
+	}
+	if len(sections) == 1 {
+		v.record(offset, "/SectionHeader32", sections[0])
+	} else {
+		v.record(offset, "/SectionHeaders", sections)
+	}
+	index := -1
+	for i, s := range sections {
+		if s.Name == STRING_RSRC {
+			index = i
+		}
+	}
+	if index < 0 {
+		v.problem("no .rsrc section found")
+		return
+	}
+	rsrc := sections[index]
+	start, end := uint64(rsrc.PointerToRawData), uint64(rsrc.PointerToRawData)+uint64(rsrc.SizeOfRawData)
+	if end > uint64(len(v.file)) {
+		v.problem(".rsrc section at offset 0x%x, of size 0x%x, exceeds %d bytes of file", rsrc.PointerToRawData, rsrc.SizeOfRawData, len(v.file))
+		if start > uint64(len(v.file)) {
+			return
+		}
+		// check what is left of it
+		end = uint64(len(v.file))
+	}
+	v.section = v.file[start:end]
+	v.sectionStart = start
+
+	symbols := v.symbols(hdr)
+	v.dir(0, 0, "/Dir")
+
+	relocs := make([]RelocationEntry, rsrc.NumberOfRelocations)
+	if !v.read(v.file, uint64(rsrc.PointerToRelocations), "relocations", relocs) {
+		return
+	}
+	v.record(uint64(rsrc.PointerToRelocations), "/Relocations", relocs)
+	for i, r := range relocs {
+		v.relocated[r.RVA]++
+		if v.relocated[r.RVA] == 1 && !v.isLeaf(r.RVA) {
+			v.problem("relocation %d at 0x%x does not point at OffsetToData of a DataEntry", i, r.RVA)
+		}
+		if ok && r.Type != reloctype {
+			v.problem("relocation %d has type 0x%x, expected 0x%x for machine type 0x%04x", i, r.Type, reloctype, hdr.Machine)
+		}
+		if symbols == nil {
+			continue
+		}
+		sym, found := symbols[r.SymbolIndex]
+		if !found {
+			v.problem("relocation %d refers to symbol %d, not found in the symbol table", i, r.SymbolIndex)
+		} else if int(sym.SectionNumber) != index+1 || sym.Value != 0 {
+			v.problem("relocation %d refers to symbol %d, which is not the start of the .rsrc section", i, r.SymbolIndex)
+		}
+	}
+	for _, leaf := range v.leaves {
+		if n := v.relocated[leaf]; n != 1 {
+			v.problem("DataEntry at 0x%x has %d relocations, expected 1", leaf, n)
+		}
+	}
+}
+
+// symbols checks the symbol table and the string table, returning the
+// symbols by index, or nil if the symbol table is broken.
+func (v *verifier) symbols(hdr pe.FileHeader) map[uint32]pe.COFFSymbol {
+	size := uint64(hdr.NumberOfSymbols) * uint64(binary.Size(pe.COFFSymbol{}))
+	if !v.fits(v.file, uint64(hdr.PointerToSymbolTable), size, "symbol table") {
+		return nil
+	}
+	table := make([]pe.COFFSymbol, hdr.NumberOfSymbols)
+	v.read(v.file, uint64(hdr.PointerToSymbolTable), "symbol table", table)
+	end := uint64(hdr.PointerToSymbolTable) + uint64(binary.Size(table))
+	var length uint32
+	if !v.read(v.file, end, "string table", &length) {
+		return nil
+	}
+	v.record(end, "/StringsHeader", StringsHeader{length})
+	var strs []byte
+	if length < 4 || end+uint64(length) > uint64(len(v.file)) {
+		v.problem("string table at offset 0x%x, of length 0x%x, exceeds %d bytes of file", end, length, len(v.file))
+	} else {
+		strs = v.file[end : end+uint64(length)]
+		v.recordStrings(end+4, strs[4:])
+	}
+	symbols := map[uint32]pe.COFFSymbol{}
+	for i, n := 0, 0; i < len(table); i, n = i+1, n+1 {
+		sym := table[i]
+		symbols[uint32(i)] = sym
+		v.recordSymbol(uint64(hdr.PointerToSymbolTable)+uint64(i*binary.Size(sym)), n, sym, len(table)-i)
+		if binary.LittleEndian.Uint32(sym.Name[:4]) == 0 && strs != nil {
+			off := binary.LittleEndian.Uint32(sym.Name[4:])
+			if off < 4 || off >= uint32(len(strs)) || bytes.IndexByte(strs[off:], 0) < 0 {
+				v.problem("name of symbol %d at offset 0x%x outside of string table of length 0x%x", i, off, len(strs))
+			}
+		}
+		if i+int(sym.NumberOfAuxSymbols) >= len(table) {
+			v.problem("auxiliary records of symbol %d exceed the symbol table", i)
+		}
+		i += int(sym.NumberOfAuxSymbols)
+	}
+	return symbols
+}
+
+// recordSymbol records symbol n, at offset in the file, with those of its
+// auxiliary records which fit in the first count records of the table.
+func (v *verifier) recordSymbol(offset uint64, n int, sym pe.COFFSymbol, count int) {
+	size := uint64(binary.Size(sym))
+	aux := make([]Auxiliary, 0, sym.NumberOfAuxSymbols)
+	for j := uint64(1); j <= uint64(sym.NumberOfAuxSymbols) && j < uint64(count); j++ {
+		aux = append(aux, Auxiliary{})
+		copy(aux[len(aux)-1][:], v.file[offset+j*size:])
+	}
+	v.record(offset, fmt.Sprintf("/Symbols[%d]", n), Symbol{
+		Name:           sym.Name,
+		Value:          sym.Value,
+		SectionNumber:  uint16(sym.SectionNumber),
+		Type:           sym.Type,
+		StorageClass:   sym.StorageClass,
+		AuxiliaryCount: sym.NumberOfAuxSymbols,
+		Auxiliaries:    aux,
+	})
+}
+
+// recordStrings records zero-terminated strings found in strs, at offset in
+// the file.
+func (v *verifier) recordStrings(offset uint64, strs []byte) {
+	for i := 0; len(strs) > 0; i++ {
+		n := bytes.IndexByte(strs, 0) + 1
+		if n == 0 {
+			n = len(strs)
+		}
+		v.record(offset, fmt.Sprintf("/Strings[%d]", i), bytes.NewReader(strs[:n]))
+		offset += uint64(n)
+		strs = strs[n:]
+	}
+}
+
+// dir checks the directory at offset in the .rsrc section, of given depth in
+// the tree (0 for types, 1 for names and IDs, 2 for languages), recording it
+// at path.
+func (v *verifier) dir(offset uint32, depth int, path string) {
+	if offset%4 != 0 {
+		v.problem("directory at 0x%x not aligned to 4 bytes", offset)
+	}
+	var hdr struct {
+		Characteristics      uint32
+		TimeDateStamp        uint32
+		MajorVersion         uint16
+		MinorVersion         uint16
+		NumberOfNamedEntries uint16
+		NumberOfIdEntries    uint16
+	}
+	if !v.read(v.section, uint64(offset), "directory", &hdr) || !v.visit(offset, "directory") {
+		return
+	}
+	v.record(v.sectionStart+uint64(offset), path, hdr)
+	entries := make([]DirEntry, int(hdr.NumberOfNamedEntries)+int(hdr.NumberOfIdEntries))
+	if !v.read(v.section, uint64(offset)+uint64(binary.Size(hdr)), "entries of directory", entries) {
+		return
+	}
+	v.record(v.sectionStart+uint64(offset)+uint64(binary.Size(hdr)), path+"/DirEntries", entries)
+	var prevName []uint16
+	for i, e := range entries {
+		named := e.NameOrId&MASK_NAME != 0
+		switch {
+		case named != (i < int(hdr.NumberOfNamedEntries)):
+			v.problem("directory at 0x%x: entry %d does not match %d named entries, which must precede ID entries", offset, i, hdr.NumberOfNamedEntries)
+		case named:
+			name := v.name(e.NameOrId &^ MASK_NAME)
+			if i > 0 && compareUTF16(prevName, name) >= 0 {
+				v.problem("directory at 0x%x: names not sorted or not unique, %q after %q", offset, string(utf16.Decode(name)), string(utf16.Decode(prevName)))
+			}
+			prevName = name
+		case i > int(hdr.NumberOfNamedEntries) && e.NameOrId <= entries[i-1].NameOrId:
+			v.problem("directory at 0x%x: IDs not sorted or not unique, %d after %d", offset, e.NameOrId, entries[i-1].NameOrId)
+		}
+		sub := e.OffsetToData&MASK_SUBDIRECTORY != 0
+		switch {
+		case sub && depth < 2:
+			v.dir(e.OffsetToData&^MASK_SUBDIRECTORY, depth+1, fmt.Sprintf("%s/Dirs[%d]", path, i))
+		case !sub && depth == 2:
+			v.leaf(e.OffsetToData)
+		case sub:
+			v.problem("directory at 0x%x: entry %d points at a subdirectory, expected a DataEntry at depth %d", offset, i, depth)
+		default:
+			v.problem("directory at 0x%x: entry %d points at a DataEntry, expected a subdirectory at depth %d", offset, i, depth)
+		}
+	}
+}
+
+func (v *verifier) name(offset uint32) []uint16 {
+	if offset%2 != 0 {
+		v.problem("name at 0x%x not aligned to 2 bytes", offset)
+	}
+	var n uint16
+	if !v.read(v.section, uint64(offset), "name", &n) {
+		return nil
+	}
+	u := make([]uint16, n)
+	if !v.read(v.section, uint64(offset)+2, "name", u) {
+		return nil
+	}
+	v.names[offset] = u
+	return u
+}
+
+func (v *verifier) leaf(offset uint32) {
+	if offset%4 != 0 {
+		v.problem("DataEntry at 0x%x not aligned to 4 bytes", offset)
+	}
+	var entry DataEntry
+	if !v.read(v.section, uint64(offset), "DataEntry", &entry) || !v.visit(offset, "DataEntry") {
+		return
+	}
+	n := len(v.leaves)
+	v.record(v.sectionStart+uint64(offset), fmt.Sprintf("/DataEntries[%d]", n), entry)
+	v.leaves = append(v.leaves, offset)
+	start, end := uint64(entry.OffsetToData), uint64(entry.OffsetToData)+uint64(entry.Size1)
+	if end > uint64(len(v.section)) {
+		v.problem("data of DataEntry at 0x%x, at 0x%x of size 0x%x, exceeds 0x%x bytes of .rsrc section", offset, entry.OffsetToData, entry.Size1, len(v.section))
+	} else {
+		pad := -end & 7
+		if end+pad > uint64(len(v.section)) {
+			pad = 0
+		}
+		v.record(v.sectionStart+start, fmt.Sprintf("/Data[%d]", n), PaddedData{
+			Data:    bytes.NewReader(v.section[start:end]),
+			Padding: v.section[end : end+pad],
+		})
+	}
+	if entry.OffsetToData%8 != 0 {
+		v.problem("data of DataEntry at 0x%x, at 0x%x, not aligned to 8 bytes", offset, entry.OffsetToData)
+	}
+}
+
+// visit marks a directory or DataEntry at offset as visited, reporting a
+// problem if it was already, i.e. if it is shared by more than one entry.
+func (v *verifier) visit(offset uint32, what string) bool {
+	if v.visited[offset] != "" {
+		v.problem("%s at 0x%x used by more than one directory entry", what, offset)
+		return false
+	}
+	v.visited[offset] = what
+	return true
+}
+
+func (v *verifier) isLeaf(offset uint32) bool {
+	return v.visited[offset] == "DataEntry"
+}
+
+// compareUTF16 compares a and b by UTF-16 code units.
+func compareUTF16(a, b []uint16) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
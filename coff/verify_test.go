@@ -0,0 +1,115 @@
+package coff_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+func TestVerify(t *testing.T) {
+	for _, arch := range []string{"386", "amd64", "arm", "arm64"} {
+		out := newVerifyCoff(t, arch)
+		buf := &bytes.Buffer{}
+		if _, err := out.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		if problems := coff.Verify(buf.Bytes()); problems != nil {
+			t.Errorf("%s: expected no problems, got:\n%s", arch, strings.Join(problems, "\n"))
+		}
+	}
+
+	tests := []struct {
+		path  string // of the field to overwrite
+		value uint32
+		want  string
+	}{
+		{"/FileHeader/Machine", 0x1234, "unknown machine type 0x1234"},
+		{"/SectionHeader32/SizeOfRawData", 0x10000, ".rsrc section at offset 0x3c, of size 0x10000, exceeds"},
+		{"/SectionHeader32/SizeOfRawData", 0x20, "directory at offset 0x20, of size 0x10, exceeds 32 bytes"},
+		{"/Dir/NumberOfNamedEntries", 1, "entry 0 does not match 1 named entries"},
+		{"/Dir/DirEntries[0]/NameOrId", 100, "IDs not sorted or not unique, 23 after 100"},
+		{"/Dir/Dirs[0]/DirEntries[0]/OffsetToData", 0x80000002, "directory at 0x2 not aligned to 4 bytes"},
+		{"/Dir/Dirs[0]/DirEntries[0]/OffsetToData", 0x8, "points at a DataEntry, expected a subdirectory at depth 1"},
+		{"/Dir/Dirs[1]/DirEntries[1]/NameOrId", 0x80000000, "names not sorted or not unique"},
+		{"/DataEntries[0]/OffsetToData", 0x7ffc, "exceeds"},
+		{"/DataEntries[0]/OffsetToData", 0x4, "not aligned to 8 bytes"},
+		{"/Relocations[1]/RVA", 0x14, "does not point at OffsetToData of a DataEntry"},
+		{"/Relocations[1]/RVA", 0x100, "DataEntry at 0x100 has 2 relocations, expected 1"},
+		{"/Relocations[0]/Type", 0x11, "relocation 0 has type 0x11, expected 0x3"},
+		{"/Relocations[0]/SymbolIndex", 5, "refers to symbol 5, not found"},
+		{"/Symbols[0]/SectionNumber", 2, "which is not the start of the .rsrc section"},
+		{"/FileHeader/NumberOfSymbols", 1000, "symbol table at offset"},
+		{"/StringsHeader/Length", 1000, "string table at offset"},
+	}
+	for _, tt := range tests {
+		out := newVerifyCoff(t, "amd64")
+		buf := &bytes.Buffer{}
+		if _, err := out.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		found := false
+		for _, f := range out.Layout() {
+			if f.Path != tt.path {
+				continue
+			}
+			found = true
+			switch f.Size {
+			case 2:
+				binary.LittleEndian.PutUint16(data[f.Offset:], uint16(tt.value))
+			case 4:
+				binary.LittleEndian.PutUint32(data[f.Offset:], tt.value)
+			}
+		}
+		if !found {
+			t.Fatalf("field %s not found", tt.path)
+		}
+		problems := coff.Verify(data)
+		if !strings.Contains(strings.Join(problems, "\n"), tt.want) {
+			t.Errorf("%s set to 0x%x: expected problem %q, got:\n%s", tt.path, tt.value, tt.want, strings.Join(problems, "\n"))
+		}
+	}
+
+	if problems := coff.Verify([]byte("MZ")); len(problems) != 1 || !strings.Contains(problems[0], "file header") {
+		t.Errorf("expected problem with file header, got: %q", problems)
+	}
+}
+
+func FuzzVerify(f *testing.F) {
+	buf := &bytes.Buffer{}
+	if _, err := newVerifyCoff(f, "amd64").WriteTo(buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		coff.Verify(data)
+		fields, _ := coff.ReadLayout(data)
+		for _, fl := range fields {
+			if uint64(fl.Offset)+uint64(fl.Size) > uint64(len(data)) {
+				t.Errorf("%s at offset 0x%x, of size 0x%x, exceeds %d bytes", fl.Path, fl.Offset, fl.Size, len(data))
+			}
+		}
+	})
+}
+
+func newVerifyCoff(t testing.TB, arch string) *coff.Coff {
+	out := coff.NewRSRC()
+	if err := out.Arch(arch); err != nil {
+		t.Fatal(err)
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(out.AddResource(coff.RT_ICON, 2, strings.NewReader("icon")))
+	must(out.AddNamedResource(coff.RT_HTML, "INDEX.HTML", strings.NewReader("<html/>")))
+	must(out.AddNamedResource(coff.RT_HTML, "STYLE.CSS", strings.NewReader("p{}")))
+	must(out.AddResource(coff.RT_HTML, 3, strings.NewReader("<p/>")))
+	out.Freeze()
+	return out
+}
@@ -1,3 +1,3 @@
 module github.com/akavel/rsrc
 
-go 1.12
+go 1.18
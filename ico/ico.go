@@ -102,12 +102,16 @@ func DecodeHeaders(r io.Reader) ([]ICONDIRENTRY, error) {
 		return nil, fmt.Errorf("bad magic number")
 	}
 
-	entries := make([]ICONDIRENTRY, hdr.Count)
-	for i := 0; i < len(entries); i++ {
-		err = binary.Read(r, binary.LittleEndian, &entries[i])
+	// Count is not trusted to preallocate entries, as it may exceed the
+	// size of a truncated or hostile file
+	var entries []ICONDIRENTRY
+	for i := 0; i < int(hdr.Count); i++ {
+		var e ICONDIRENTRY
+		err = binary.Read(r, binary.LittleEndian, &e)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("entry %d of %d: %w", i, hdr.Count, err)
 		}
+		entries = append(entries, e)
 	}
 	return entries, nil
 }
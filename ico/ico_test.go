@@ -0,0 +1,28 @@
+package ico_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/akavel/rsrc/ico"
+	"github.com/akavel/rsrc/internal/testutil"
+)
+
+func FuzzDecodeHeaders(f *testing.F) {
+	testutil.AddFiles(f, filepath.Join("..", "testdata", "*.ico"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, err := ico.DecodeHeaders(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		n := binary.Size(ico.ICONDIR{}) + len(entries)*binary.Size(ico.ICONDIRENTRY{})
+		if n > len(data) {
+			t.Errorf("decoded %d entries, needing %d bytes, from %d bytes", len(entries), n, len(data))
+		}
+		if count := binary.LittleEndian.Uint16(data[4:]); int(count) != len(entries) {
+			t.Errorf("decoded %d entries, but header has count %d", len(entries), count)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+// Package testutil holds helpers shared by tests of several packages.
+package testutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+// AddFiles adds contents of files matching pattern to the seed corpus of f.
+func AddFiles(f *testing.F, pattern string) {
+	f.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, fname := range files {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+// CheckDataSizes reports resources, read from size bytes of input, which got
+// more data than the whole input.
+func CheckDataSizes(t *testing.T, resources []coff.Resource, size int) {
+	t.Helper()
+	for _, r := range resources {
+		if n := r.Data.(*bytes.Reader).Size(); n > int64(size) {
+			t.Errorf("%s: got %d bytes of data from %d bytes", r, n, size)
+		}
+	}
+}
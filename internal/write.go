@@ -1,40 +1,60 @@
 package internal
 
 import (
-	"fmt"
+	"bytes"
+	"io/ioutil"
 	"os"
-	"reflect"
+	"path/filepath"
 
-	"github.com/akavel/rsrc/binutil"
 	"github.com/akavel/rsrc/coff"
 )
 
-// TODO(akavel): maybe promote this to coff.Coff.WriteTo(io.Writer) (int64, error)
+// Write writes coff to file fnameout, as described in WriteFile.
 func Write(coff *coff.Coff, fnameout string) error {
-	out, err := os.Create(fnameout)
+	buf := &bytes.Buffer{}
+	_, err := coff.WriteTo(buf)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-	w := binutil.Writer{W: out}
+	return WriteFile(fnameout, buf.Bytes())
+}
 
-	// write the resulting file to disk
-	binutil.Walk(coff, func(v reflect.Value, path string) error {
-		if binutil.Plain(v.Kind()) {
-			w.WriteLE(v.Interface())
-			return nil
-		}
-		vv, ok := v.Interface().(binutil.SizedReader)
-		if ok {
-			w.WriteFromSized(vv)
-			return binutil.WALK_SKIP
-		}
+// WriteFile writes data to file fname, or to standard output if fname is
+// "-". An existing file is replaced atomically, via a temporary file renamed
+// over it, and is not modified at all if it already has the same contents,
+// so that its modification time stays unchanged for build caches.
+func WriteFile(fname string, data []byte) error {
+	if fname == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	old, err := ioutil.ReadFile(fname)
+	if err == nil && bytes.Equal(old, data) {
 		return nil
-	})
-
-	if w.Err != nil {
-		return fmt.Errorf("Error writing output file: %s", w.Err)
+	}
+	// TempFile creates files readable only by the owner
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(fname); err == nil {
+		mode = info.Mode().Perm()
 	}
 
+	tmp, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "out.syso")
+
+	check := func(want string) {
+		t.Helper()
+		got, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("got contents %q, want %q", got, want)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		if len(files) != 1 {
+			t.Errorf("expected no temporary files left, got: %v", files)
+		}
+	}
+
+	if err := WriteFile(fname, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	check("first")
+	info, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0044 == 0 {
+		t.Errorf("new file not readable by others: %v", info.Mode())
+	}
+
+	// make modification visible even on filesystems with coarse timestamps
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(fname, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fname, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	check("first")
+	if info, err := os.Stat(fname); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("unchanged file was modified: %v, %v", info.ModTime(), err)
+	}
+
+	if err := WriteFile(fname, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	check("second")
+	if info, err := os.Stat(fname); err != nil || info.ModTime().Equal(old) {
+		t.Errorf("changed file was not modified: %v", err)
+	}
+}
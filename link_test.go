@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/ico"
+)
+
+// cSharedSource is a library for -buildmode=c-shared, which requires cgo.
+const cSharedSource = `package main
+
+import "C"
+
+//export Hello
+func Hello() {}
+
+func main() {}
+`
+
+// mingwCC lists names of C cross compilers for cgo, by GOARCH.
+var mingwCC = map[string][]string{
+	"386":   {"i686-w64-mingw32-gcc", "i686-w64-mingw32-clang"},
+	"amd64": {"x86_64-w64-mingw32-gcc", "x86_64-w64-mingw32-clang"},
+	"arm":   {"armv7-w64-mingw32-gcc", "armv7-w64-mingw32-clang"},
+	"arm64": {"aarch64-w64-mingw32-gcc", "aarch64-w64-mingw32-clang"},
+}
+
+// TestLinkAllArchs links resources generated by rsrc into apps for every
+// architecture and build mode, then checks that the manifest and icons found
+// in the resulting PE files are the same as the input files.
+//
+// Build modes which need cgo are skipped if no MinGW cross compiler (see
+// mingwCC) is found in PATH: on Debian and Ubuntu, package gcc-mingw-w64
+// provides the ones for 386 and amd64, and llvm-mingw
+// (https://github.com/mstorsjo/llvm-mingw) those for arm and arm64. To fail
+// instead, as in CI, set $RSRC_TEST_CGO to the architectures whose compilers
+// must be there, e.g. RSRC_TEST_CGO=386,amd64, or to "all". Build modes not
+// supported by the Go toolchain are skipped too. Skipped builds are listed
+// together at the end of the test.
+func TestLinkAllArchs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping builds for all architectures in short mode")
+	}
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+
+	icons := map[uint16]string{10: "akavel.ico", 20: "syncthing.ico"}
+	sources := map[string]string{
+		"exe": string(mustRead(t, filepath.Join("testdata", "tmp.go"))),
+		"lib": cSharedSource,
+	}
+	for dir, src := range sources {
+		dir = filepath.Join(tmp, dir)
+		mustWrite(t, filepath.Join(dir, "main.go"), []byte(src))
+		mustWrite(t, filepath.Join(dir, "go.mod"), []byte("module app\n"))
+		cmd := exec.Command(exe, "-manifest", "manifest.xml", "-ico", "akavel.ico@10,syncthing.ico@20", "-arch", "all", "-o", filepath.Join(dir, "rsrc_windows_{arch}.syso"))
+		cmd.Dir = "testdata"
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var skipped []string
+	for _, arch := range allArchs {
+		for _, mode := range []string{"exe", "pie", "c-shared"} {
+			name := arch + "/" + mode
+			dir := filepath.Join(tmp, "exe")
+			env := []string{"GOOS=windows", "GOARCH=" + arch, "CGO_ENABLED=0"}
+			if mode == "c-shared" {
+				dir = filepath.Join(tmp, "lib")
+				cc := findCC(mingwCC[arch])
+				if cc == "" {
+					msg := "no C cross compiler found, tried: " + strings.Join(mingwCC[arch], ", ")
+					if cgoRequired(arch) {
+						t.Errorf("%s: %s, but required by $RSRC_TEST_CGO", name, msg)
+					} else {
+						skipped = append(skipped, name+": "+msg)
+					}
+					continue
+				}
+				env = append(env, "CGO_ENABLED=1", "CC="+cc)
+			}
+			t.Run(name, func(t *testing.T) {
+				out := filepath.Join(tmp, arch+"-"+mode+".bin")
+				cmd := exec.Command("go", "build", "-buildmode="+mode, "-o", out)
+				cmd.Dir = dir
+				cmd.Env = append(os.Environ(), env...)
+				msg, err := cmd.CombinedOutput()
+				if strings.Contains(string(msg), "not supported on windows/"+arch) {
+					reason := strings.TrimSpace(string(msg))
+					skipped = append(skipped, name+": "+reason)
+					t.Skip(reason)
+				}
+				if err != nil {
+					t.Fatalf("%v\n%s", err, msg)
+				}
+				checkLinkedResources(t, out, icons)
+			})
+		}
+	}
+	if skipped != nil {
+		t.Logf("skipped %d builds:\n%s", len(skipped), strings.Join(skipped, "\n"))
+	}
+}
+
+// cgoRequired reports if builds with cgo for arch must not be skipped, as
+// listed in $RSRC_TEST_CGO.
+func cgoRequired(arch string) bool {
+	for _, a := range splitList(os.Getenv("RSRC_TEST_CGO")) {
+		if a == arch || a == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLinkedResources checks that the PE file fname holds the manifest and
+// the icons from testdata, with the .ico files given by IDs of their groups.
+func checkLinkedResources(t *testing.T, fname string, icons map[uint16]string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	resources, err := coff.ReadRSRC(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string][]byte{}
+	for _, r := range resources {
+		data[resourceID(r.Type, r.ID)], err = ioutil.ReadAll(r.Data.(*bytes.Reader))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(data) != len(resources) {
+		t.Errorf("got %d resources, but only %d different types and IDs", len(resources), len(data))
+	}
+
+	manifest := mustRead(t, filepath.Join("testdata", "manifest.xml"))
+	if got := data[resourceID(coff.RT_MANIFEST, 1)]; !bytes.Equal(got, manifest) {
+		t.Errorf("manifest differs, got %d bytes, want %d", len(got), len(manifest))
+	}
+	images := 0
+	for id, name := range icons {
+		icofile := mustRead(t, filepath.Join("testdata", name))
+		entries, err := ico.DecodeHeaders(bytes.NewReader(icofile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(data[resourceID(coff.RT_GROUP_ICON, id)])
+		var hdr ico.ICONDIR
+		if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil || int(hdr.Count) != len(entries) {
+			t.Errorf("%s: got group of %d icons, want %d, error: %v", name, hdr.Count, len(entries), err)
+			continue
+		}
+		for i, want := range entries {
+			var got struct {
+				ico.IconDirEntryCommon
+				ID uint16
+			}
+			if err := binary.Read(r, binary.LittleEndian, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.IconDirEntryCommon != want.IconDirEntryCommon {
+				t.Errorf("%s: image %d: got %+v in group, want %+v", name, i, got.IconDirEntryCommon, want.IconDirEntryCommon)
+			}
+			img := icofile[want.ImageOffset : want.ImageOffset+want.BytesInRes]
+			if !bytes.Equal(data[resourceID(coff.RT_ICON, got.ID)], img) {
+				t.Errorf("%s: image %d differs from RT_ICON %d", name, i, got.ID)
+			}
+			images++
+		}
+	}
+	if n := len(resources) - images - len(icons); n != 1 {
+		t.Errorf("got %d resources other than icons, want only the manifest", n)
+	}
+}
+
+func resourceID(kind uint32, id uint16) string {
+	return coff.Resource{Type: kind, ID: id}.String()
+}
+
+func findCC(names []string) string {
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func mustRead(t *testing.T, fname string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// mustWrite writes a file, creating its directory if needed.
+func mustWrite(t *testing.T, fname string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package msgtable
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"unicode"
+)
+
+// WriteGo writes Go source code of package pkg, declaring constants with
+// IDs of all messages that have a SymbolicName.
+func WriteGo(w io.Writer, pkg string, f *File) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rsrc; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&buf, "// Message IDs, for use as event IDs with the Windows Event Log.\nconst (\n")
+	for _, m := range f.Messages {
+		if m.SymbolicName == "" {
+			continue
+		}
+		if !isIdentifier(m.SymbolicName) {
+			return fmt.Errorf("msgtable: SymbolicName %q is not a valid Go identifier", m.SymbolicName)
+		}
+		fmt.Fprintf(&buf, "\t%s = 0x%08X\n", m.SymbolicName, m.ID)
+	}
+	fmt.Fprintf(&buf, ")\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("msgtable: error formatting Go code: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func isIdentifier(name string) bool {
+	for i, c := range name {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return name != ""
+}
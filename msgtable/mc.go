@@ -0,0 +1,236 @@
+package msgtable
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type symbolValue struct {
+	value uint32
+	sym   string
+}
+
+// Parse reads a message text file in the format accepted by mc.exe. Header
+// keywords other than SeverityNames, FacilityNames and LanguageNames are
+// ignored, as are comment lines starting with ';'.
+func Parse(r io.Reader) (*File, error) {
+	p := parser{
+		scanner: bufio.NewScanner(r),
+		severities: map[string]symbolValue{
+			"success":       {0x0, "STATUS_SEVERITY_SUCCESS"},
+			"informational": {0x1, "STATUS_SEVERITY_INFORMATIONAL"},
+			"warning":       {0x2, "STATUS_SEVERITY_WARNING"},
+			"error":         {0x3, "STATUS_SEVERITY_ERROR"},
+		},
+		facilities: map[string]symbolValue{
+			"system":      {0x0FF, "FACILITY_SYSTEM"},
+			"application": {0xFFF, "FACILITY_APPLICATION"},
+		},
+		languages: map[string]symbolValue{
+			"english": {0x409, "MSG00409"},
+		},
+	}
+	f, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("msgtable: line %d: %s", p.line, err)
+	}
+	return f, nil
+}
+
+type parser struct {
+	scanner    *bufio.Scanner
+	line       int
+	severities map[string]symbolValue
+	facilities map[string]symbolValue
+	languages  map[string]symbolValue
+}
+
+func (p *parser) next() (string, bool) {
+	if !p.scanner.Scan() {
+		return "", false
+	}
+	p.line++
+	return strings.TrimRight(p.scanner.Text(), "\r"), true
+}
+
+func (p *parser) parse() (*File, error) {
+	f := &File{}
+	usedLangs := map[uint16]bool{}
+	var (
+		msg                *Message
+		lastID             uint32
+		severity, facility uint32
+		haveID             bool
+	)
+	for {
+		line, ok := p.next()
+		if !ok {
+			break
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == ';' {
+			continue
+		}
+		eq := strings.IndexByte(trimmed, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("expected 'Keyword=Value', got %q", trimmed)
+		}
+		key := strings.ToLower(strings.TrimSpace(trimmed[:eq]))
+		value := strings.TrimSpace(trimmed[eq+1:])
+
+		switch key {
+		case "severitynames", "facilitynames", "languagenames":
+			names, err := p.parseNames(value)
+			if err != nil {
+				return nil, err
+			}
+			table := map[string]map[string]symbolValue{
+				"severitynames": p.severities,
+				"facilitynames": p.facilities,
+				"languagenames": p.languages,
+			}[key]
+			for k, v := range names {
+				table[k] = v
+			}
+		case "messageid":
+			id, err := p.parseID(value, lastID, haveID)
+			if err != nil {
+				return nil, err
+			}
+			f.Messages = append(f.Messages, Message{ID: id, Text: map[uint16]string{}})
+			msg = &f.Messages[len(f.Messages)-1]
+			lastID, haveID = id, true
+		case "severity", "facility":
+			table := p.severities
+			if key == "facility" {
+				table = p.facilities
+			}
+			v, ok := table[strings.ToLower(value)]
+			if !ok {
+				return nil, fmt.Errorf("unknown %s %q", key, value)
+			}
+			if key == "severity" {
+				severity = v.value
+			} else {
+				facility = v.value
+			}
+		case "symbolicname":
+			if msg == nil {
+				return nil, fmt.Errorf("SymbolicName outside of a message")
+			}
+			msg.SymbolicName = value
+		case "language":
+			if msg == nil {
+				return nil, fmt.Errorf("Language outside of a message")
+			}
+			lang, ok := p.languages[strings.ToLower(value)]
+			if !ok {
+				return nil, fmt.Errorf("unknown language %q", value)
+			}
+			if _, dup := msg.Text[uint16(lang.value)]; dup {
+				return nil, fmt.Errorf("duplicate text for language %q", value)
+			}
+			text, err := p.parseText()
+			if err != nil {
+				return nil, err
+			}
+			msg.ID = severity<<30 | facility<<16 | msg.ID&0xffff
+			msg.Text[uint16(lang.value)] = text
+			if !usedLangs[uint16(lang.value)] {
+				usedLangs[uint16(lang.value)] = true
+				f.Languages = append(f.Languages, Language{Name: value, ID: uint16(lang.value)})
+			}
+		case "messageidtypedef", "outputbase":
+			// only relevant for mc.exe header output
+		default:
+			return nil, fmt.Errorf("unknown keyword %q", trimmed[:eq])
+		}
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, m := range f.Messages {
+		if len(m.Text) == 0 {
+			return nil, fmt.Errorf("message 0x%X has no text", m.ID)
+		}
+	}
+	return f, nil
+}
+
+// parseID handles "MessageId=", "MessageId=+N" and "MessageId=N".
+func (p *parser) parseID(value string, last uint32, haveLast bool) (uint32, error) {
+	next := uint32(0)
+	if haveLast {
+		next = last&0xffff + 1
+	}
+	switch {
+	case value == "":
+		return next, nil
+	case value[0] == '+':
+		n, err := strconv.ParseUint(value[1:], 0, 16)
+		if err != nil {
+			return 0, fmt.Errorf("bad MessageId %q: %s", value, err)
+		}
+		return last&0xffff + uint32(n), nil
+	}
+	n, err := strconv.ParseUint(value, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad MessageId %q: %s", value, err)
+	}
+	return uint32(n), nil
+}
+
+// parseNames handles "(Name=0xN:SYMBOL ...)" lists, possibly spanning multiple
+// lines.
+func (p *parser) parseNames(value string) (map[string]symbolValue, error) {
+	for !strings.Contains(value, ")") {
+		line, ok := p.next()
+		if !ok {
+			return nil, fmt.Errorf("unterminated names list")
+		}
+		value += " " + line
+	}
+	value = strings.TrimSpace(value)
+	if value[0] != '(' || value[len(value)-1] != ')' {
+		return nil, fmt.Errorf("expected names list in parentheses, got %q", value)
+	}
+	names := map[string]symbolValue{}
+	for _, field := range strings.Fields(value[1 : len(value)-1]) {
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("expected 'Name=Value[:Symbol]', got %q", field)
+		}
+		v := symbolValue{}
+		num := field[eq+1:]
+		if colon := strings.IndexByte(num, ':'); colon >= 0 {
+			num, v.sym = num[:colon], num[colon+1:]
+		}
+		n, err := strconv.ParseUint(num, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad value in %q: %s", field, err)
+		}
+		v.value = uint32(n)
+		names[strings.ToLower(field[:eq])] = v
+	}
+	return names, nil
+}
+
+// parseText reads message text up to a line containing a single period. As
+// in mc.exe, each line is terminated with CR LF.
+func (p *parser) parseText() (string, error) {
+	var text strings.Builder
+	for {
+		line, ok := p.next()
+		if !ok {
+			return "", fmt.Errorf("message text not terminated with a '.' line")
+		}
+		if line == "." {
+			return text.String(), nil
+		}
+		text.WriteString(line)
+		text.WriteString("\r\n")
+	}
+}
@@ -0,0 +1,115 @@
+// Package msgtable describes Windows message table resources
+// (RT_MESSAGETABLE), as compiled by mc.exe from .mc files.
+package msgtable
+
+// MESSAGE_RESOURCE_DATA: https://docs.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-message_resource_data
+// Message Text Files: https://docs.microsoft.com/en-us/windows/win32/wes/message-text-files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+const (
+	MESSAGE_RESOURCE_UNICODE = 0x0001
+)
+
+type MESSAGE_RESOURCE_DATA struct {
+	NumberOfBlocks uint32
+}
+
+type MESSAGE_RESOURCE_BLOCK struct {
+	LowId           uint32
+	HighId          uint32
+	OffsetToEntries uint32 // from the beginning of MESSAGE_RESOURCE_DATA
+}
+
+type MESSAGE_RESOURCE_ENTRY struct {
+	Length uint16 // of the whole entry, including this header and padding
+	Flags  uint16
+	// followed by Text
+}
+
+// Message is a single message from a message text file.
+type Message struct {
+	ID           uint32            // full message ID, including severity and facility bits
+	SymbolicName string            // may be empty
+	Text         map[uint16]string // by language ID
+}
+
+// Language is a language declared in a message text file.
+type Language struct {
+	Name string
+	ID   uint16
+}
+
+// File is the contents of a message text (.mc) file.
+type File struct {
+	Messages  []Message
+	Languages []Language // only the languages actually used by Messages
+}
+
+// Encode builds the binary contents of a RT_MESSAGETABLE resource for the
+// specified language, with Unicode entries.
+func (f *File) Encode(lang uint16) ([]byte, error) {
+	msgs := append([]Message(nil), f.Messages...)
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+
+	// group consecutive IDs into blocks
+	var blocks []MESSAGE_RESOURCE_BLOCK
+	for i, m := range msgs {
+		if i > 0 && m.ID == msgs[i-1].ID {
+			return nil, fmt.Errorf("msgtable: duplicate message ID 0x%X", m.ID)
+		}
+		if len(blocks) > 0 && blocks[len(blocks)-1].HighId+1 == m.ID {
+			blocks[len(blocks)-1].HighId = m.ID
+			continue
+		}
+		blocks = append(blocks, MESSAGE_RESOURCE_BLOCK{LowId: m.ID, HighId: m.ID})
+	}
+
+	var entries bytes.Buffer
+	offset := uint32(binary.Size(MESSAGE_RESOURCE_DATA{}) + len(blocks)*binary.Size(MESSAGE_RESOURCE_BLOCK{}))
+	b := 0
+	for i, m := range msgs {
+		if m.ID == blocks[b].LowId {
+			blocks[b].OffsetToEntries = offset + uint32(entries.Len())
+		}
+		if m.ID == blocks[b].HighId {
+			b++
+		}
+		text, ok := m.Text[lang]
+		if !ok {
+			return nil, fmt.Errorf("msgtable: message 0x%X has no text for language 0x%04X", m.ID, lang)
+		}
+		err := writeEntry(&entries, text)
+		if err != nil {
+			return nil, fmt.Errorf("msgtable: message #%d (0x%X): %s", i, m.ID, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, MESSAGE_RESOURCE_DATA{NumberOfBlocks: uint32(len(blocks))})
+	binary.Write(&buf, binary.LittleEndian, blocks)
+	buf.Write(entries.Bytes())
+	return buf.Bytes(), nil
+}
+
+func writeEntry(buf *bytes.Buffer, text string) error {
+	u := utf16.Encode([]rune(text + "\000"))
+	for len(u)%2 != 0 {
+		u = append(u, 0) // pad to 4 bytes
+	}
+	length := binary.Size(MESSAGE_RESOURCE_ENTRY{}) + 2*len(u)
+	if length > 0xffff {
+		return fmt.Errorf("message text too long")
+	}
+	binary.Write(buf, binary.LittleEndian, MESSAGE_RESOURCE_ENTRY{
+		Length: uint16(length),
+		Flags:  MESSAGE_RESOURCE_UNICODE,
+	})
+	return binary.Write(buf, binary.LittleEndian, u)
+}
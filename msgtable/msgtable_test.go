@@ -0,0 +1,66 @@
+package msgtable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestParseAndEncode(t *testing.T) {
+	in, err := os.Open("../testdata/events.mc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	f, err := Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Languages) != 2 || f.Languages[0].ID != 0x409 || f.Languages[1].ID != 0x415 {
+		t.Fatalf("unexpected languages: %+v", f.Languages)
+	}
+	wantIDs := []uint32{0x4FFF0001, 0xCFFF0002}
+	if len(f.Messages) != len(wantIDs) {
+		t.Fatalf("got %d messages, want %d", len(f.Messages), len(wantIDs))
+	}
+	for i, id := range wantIDs {
+		if f.Messages[i].ID != id {
+			t.Errorf("message %d: got ID 0x%X, want 0x%X", i, f.Messages[i].ID, id)
+		}
+	}
+	if got := f.Messages[1].Text[0x409]; got != "Service %1 failed:\r\n%2\r\n" {
+		t.Errorf("unexpected text: %q", got)
+	}
+
+	data, err := f.Encode(0x409)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+	var hdr MESSAGE_RESOURCE_DATA
+	binary.Read(r, binary.LittleEndian, &hdr)
+	blocks := make([]MESSAGE_RESOURCE_BLOCK, hdr.NumberOfBlocks)
+	binary.Read(r, binary.LittleEndian, blocks)
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2 (IDs are not consecutive)", len(blocks))
+	}
+	if blocks[0].LowId != 0x4FFF0001 || blocks[1].LowId != 0xCFFF0002 {
+		t.Errorf("unexpected blocks: %+v", blocks)
+	}
+
+	var entry MESSAGE_RESOURCE_ENTRY
+	r.Seek(int64(blocks[1].OffsetToEntries), 0)
+	binary.Read(r, binary.LittleEndian, &entry)
+	if entry.Flags != MESSAGE_RESOURCE_UNICODE || entry.Length%4 != 0 {
+		t.Fatalf("unexpected entry header: %+v", entry)
+	}
+	text := make([]uint16, (entry.Length-4)/2)
+	binary.Read(r, binary.LittleEndian, text)
+	if got := strings.TrimRight(string(utf16.Decode(text)), "\000"); got != f.Messages[1].Text[0x409] {
+		t.Errorf("encoded text %q, want %q", got, f.Messages[1].Text[0x409])
+	}
+}
@@ -1,51 +1,458 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/internal"
+	"github.com/akavel/rsrc/msgtable"
 	"github.com/akavel/rsrc/rsrc"
 )
 
 var usage = `USAGE:
 
-%s [-manifest FILE.exe.manifest] [-ico FILE.ico[,FILE2.ico...]] [OPTIONS...]
+%s [-manifest FILE.exe.manifest] [-ico FILE.ico[,FILE2.ico...]] [-bmp FILE.bmp[,FILE2.png...]] [OPTIONS...]
   Generates a .syso file with specified resources embedded in .rsrc section,
   aimed for consumption by Go linker when building Win32 excecutables.
 
+%s check [OPTIONS...]
+  Verifies that the files which would be generated are the same as existing
+  ones, without writing anything. Exits with status 1 and lists differences of
+  resources (e.g. 'RT_ICON 3 differs: 4286 vs 9662 bytes') if any file is out
+  of date. Useful in CI, for checked-in .syso files.
+
+%s diff FILE_A FILE_B
+  Lists resources added, removed and changed between two .syso, .res, .exe or
+  .dll files, matched by type, name or ID, and language. Changed manifests and
+  other text resources, version information and icons are compared in detail.
+  Exits with status 1 if any differences are found, 2 on errors.
+
+%s verify FILE...
+  Checks .syso files, also ones not generated by rsrc, for problems which may
+  break linking or loading of resources: unsorted or duplicate entries of
+  resource directories, offsets outside of the .rsrc section, missing or
+  misplaced relocations, broken symbol or string tables, and misaligned data.
+  Exits with status 1 if any problems are found, 2 on errors.
+
+%s layout [OPTIONS...]
+%s layout FILE...
+  Prints the layout of the .syso files which would be generated, without
+  writing anything: every field with its file offset, size, path (e.g.
+  '/Dir/Dirs[0]/DirEntries[1]/OffsetToData') and value, like a linker map.
+  Given existing .syso files instead of options, prints their layout, also
+  for files not generated by rsrc or broken ones, as far as they can be read,
+  followed by problems found like by 'verify'. Bytes not covered by any field
+  are listed as '(unused)'. Exits with status 1 if any problems are found.
+
+Input files of -manifest, -ico, -bmp, -accel and -mc can be given as
+[SYMBOL=]PATH[@ID] (e.g. 'IDI_APP=app.ico@101'), to pin the resource ID and
+its symbolic name in generated files, independently of order of arguments.
+IDs are pinned separately for each resource type. Without an explicit ID, the
+manifest gets ID 1, the only one loaded by Windows, and other resources get
+the lowest IDs from -idrange not used yet.
+
 The generated *.syso files should get automatically recognized by 'go build'
 command and linked into an executable/library, as long as there are any *.go
-files in the same directory.
+files in the same directory. Output files are replaced atomically, and are
+not touched at all if their contents would not change, to keep build caches
+warm. Output is reproducible: time stamps are taken from the SOURCE_DATE_EPOCH
+environment variable if set, and are zero otherwise. Automatic IDs are
+assigned in order of the files in lists (so that e.g. the first icon of -ico,
+shown by Explorer, gets the lowest ID), so reordering a list changes the
+output, unless all of its IDs are pinned; images of icons always get
+automatic IDs.
+
+When run without any arguments (e.g. as '//go:generate rsrc'), options are
+read from 'rsrc.json' or 'winres/rsrc.json' config files, found in the current
+directory and its parents up to the module root. Each file holds a JSON object
+with keys named like the options below (lists as arrays), plus "version" with
+"file-version", "product-version", "lang", "strings" (e.g. "CompanyName"),
+"tables" with strings in other languages (e.g. "0415": {...}) and an optional
+"translation" list. Values from files closer to the current directory override
+the others, and input paths are relative to the directory of the file.
 
 OPTIONS:
 `
 
 func main() {
-	//TODO: allow in options advanced specification of multiple resources, as a tree (json?)
 	//FIXME: verify that data file size doesn't exceed uint32 max value
-	var fnamein, fnameico, fnameout, arch string
+	var cfg rsrc.Config
+	var fnameico, fnamebmp, datainclude, dataexclude string
 	flags := flag.NewFlagSet("", flag.ExitOnError)
-	flags.StringVar(&fnamein, "manifest", "", "path to a Windows manifest file to embed")
+	flags.StringVar(&cfg.Manifest, "manifest", "", "path to a Windows manifest file to embed")
 	flags.StringVar(&fnameico, "ico", "", "comma-separated list of paths to .ico files to embed")
-	flags.StringVar(&fnameout, "o", "", "name of output COFF (.res or .syso) file; if set to empty, will default to 'rsrc_windows_{arch}.syso'")
-	flags.StringVar(&arch, "arch", "amd64", "architecture of output file - one of: 386, amd64, [EXPERIMENTAL: arm, arm64]")
+	flags.StringVar(&fnamebmp, "bmp", "", "comma-separated list of paths to .bmp or .png files to embed as bitmaps")
+	flags.StringVar(&cfg.Accelerators, "accel", "", "path to a text file with keyboard accelerators to embed, one 'KEY CMDID' pair per line (e.g.: 'Ctrl+Shift+S 100')")
+	flags.StringVar(&cfg.MessageTable, "mc", "", "path to a message text (.mc) file to compile and embed as a message table")
+	flags.StringVar(&cfg.MessageIDs, "mcgo", "", "if set, write Go constants with message IDs from the -mc file to this path, in package $GOPACKAGE (or main)")
+	flags.StringVar(&cfg.HTMLDir, "html", "", "path to a directory with HTML, CSS, JS and image files to embed, for loading via res://app.exe/file.html (files in subdirectories by base name, which must be unique)")
+	flags.StringVar(&cfg.DataDir, "data", "", "path to a directory with files to embed as RCDATA, together with an index resource named "+rsrc.DataIndexName)
+	flags.StringVar(&datainclude, "data-include", "", "comma-separated glob patterns of files to embed from the -data directory; patterns without '/' match file base names")
+	flags.StringVar(&dataexclude, "data-exclude", "", "comma-separated glob patterns of files to skip in the -data directory")
+	flags.StringVar(&cfg.Output, "o", "", "name of output COFF (.res or .syso) file, or '-' for standard output; '{arch}' is replaced with the architecture; if set to empty, will default to 'rsrc_windows_{arch}.syso'")
+	flags.StringVar(&cfg.Accessors, "accessors", "", "if set, write a Go file with IDs of embedded resources and functions loading them at runtime to this path (e.g. 'rsrc_windows.go'), in package $GOPACKAGE (or main)")
+	flags.StringVar(&cfg.Header, "header", "", "if set, write a C header with #defines of IDs of embedded resources to this path (e.g. 'resource.h')")
+	flags.StringVar(&cfg.GoIDs, "goids", "", "if set, write a Go file with constants of IDs of embedded resources to this path (e.g. 'ids.go'), in package $GOPACKAGE (or main); not needed with -accessors")
+	flags.StringVar(&cfg.IDRange, "idrange", "", "range of IDs for resources without explicit IDs, as FIRST-LAST (e.g. '100-199'); IDs can be pinned by giving input files as [SYMBOL=]PATH[@ID]")
+	flags.StringVar(&cfg.VersionFrom, "version-from", "", "if set to 'vcs', embed version information with version numbers from the nearest git tag (vMAJOR.MINOR.PATCH) and the number of commits since it, and the module path as ProductName")
+	flags.StringVar(&cfg.Arch, "arch", "", "comma-separated architectures of output files - any of: 386, amd64, [EXPERIMENTAL: arm, arm64], or 'all'; defaults to $GOARCH, or amd64 if unset")
 	flags.Usage = func() {
-		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 		flags.PrintDefaults()
 	}
-	_ = flags.Parse(os.Args[1:])
-	if fnamein == "" && fnameico == "" {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "diff" {
+		os.Exit(diffFiles(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "verify" {
+		os.Exit(verifyFiles(args[1:]))
+	}
+	if len(args) > 1 && args[0] == "layout" && !strings.HasPrefix(args[1], "-") {
+		os.Exit(layoutFiles(args[1:]))
+	}
+	out := &output{}
+	if len(args) > 0 && (args[0] == "check" || args[0] == "layout") {
+		out.check = args[0] == "check"
+		out.layout = args[0] == "layout"
+		args = args[1:]
+	}
+	_ = flags.Parse(args)
+	if flags.NFlag() == 0 && flags.NArg() == 0 {
+		loaded, err := rsrc.LoadConfig(".")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if loaded != nil {
+			cfg = *loaded
+		}
+	} else {
+		cfg.Icons = splitList(fnameico)
+		cfg.Bitmaps = splitList(fnamebmp)
+		cfg.DataInclude = splitList(datainclude)
+		cfg.DataExclude = splitList(dataexclude)
+	}
+	files, err := cfg.Files()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if files.Manifest == "" && files.Icons == nil && files.Bitmaps == nil && files.Accelerators == "" && files.MessageTable == "" && files.HTMLDir == "" && files.DataDir == "" && files.VersionInfo == nil {
 		flags.Usage()
 		os.Exit(1)
 	}
-	if fnameout == "" {
-		fnameout = "rsrc_windows_" + arch + ".syso"
+	err = run(out, &cfg, files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	if len(out.stale) > 0 {
+		fmt.Fprintln(os.Stderr, "rsrc: generated files are out of date:")
+		for _, s := range out.stale {
+			fmt.Fprintln(os.Stderr, "  "+s)
+		}
+		os.Exit(1)
+	}
+}
 
-	err := rsrc.Embed(fnameout, arch, fnamein, fnameico)
+// diffFiles prints differences between resources in two files, returning the
+// exit status: 0 if there are none, 1 if there are some, 2 on error.
+func diffFiles(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "rsrc: diff needs exactly two files to compare")
+		return 2
+	}
+	a, err := rsrc.ReadResourceFile(args[0])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return 2
+	}
+	b, err := rsrc.ReadResourceFile(args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	diffs, err := rsrc.DiffResources(a, b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyFiles prints problems found by coff.Verify in COFF files, returning
+// the exit status: 0 if there are none, 1 if there are some, 2 on error.
+func verifyFiles(fnames []string) int {
+	if len(fnames) == 0 {
+		fmt.Fprintln(os.Stderr, "rsrc: verify needs files to check")
+		return 2
+	}
+	status := 0
+	for _, fname := range fnames {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, p := range coff.Verify(data) {
+			fmt.Printf("%s: %s\n", fname, p)
+			status = 1
+		}
+	}
+	return status
+}
+
+// layoutFiles prints the layout of existing COFF files, followed by problems
+// found in them.
+func layoutFiles(fnames []string) int {
+	status := 0
+	for _, fname := range fnames {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		fields, problems := coff.ReadLayout(data)
+		printLayout(fname, fields)
+		for _, p := range problems {
+			fmt.Printf("# problem: %s\n", p)
+			status = 1
+		}
+	}
+	return status
+}
+
+// splitList splits a comma-separated list, returning nil for an empty one.
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// run embeds files and writes all the other files requested in cfg.
+func run(out *output, cfg *rsrc.Config, files rsrc.Files) error {
+	archs, err := parseArchs(cfg.Arch)
+	if err != nil {
+		return err
+	}
+	fnameout := cfg.Output
+	if fnameout == "" {
+		fnameout = "rsrc_windows_{arch}.syso"
+	}
+	if len(archs) > 1 && !strings.Contains(fnameout, "{arch}") {
+		return fmt.Errorf("rsrc: -o must contain '{arch}' when writing multiple architectures, got '%s'", fnameout)
+	}
+	if cfg.GoIDs != "" && cfg.Accessors != "" {
+		return fmt.Errorf("rsrc: -goids and -accessors cannot be used together, as both declare the same constants")
+	}
+
+	if cfg.MessageIDs != "" && cfg.MessageTable == "" {
+		return fmt.Errorf("rsrc: -mcgo requires -mc")
+	}
+
+	resources, mc, err := embed(out, fnameout, archs, files)
+	if err == nil && cfg.MessageIDs != "" {
+		err = writeGoFile(out, cfg.MessageIDs, func(w io.Writer, pkg string) error {
+			return msgtable.WriteGo(w, pkg, mc)
+		})
+	}
+	if err == nil && cfg.Accessors != "" {
+		err = writeGoFile(out, cfg.Accessors, func(w io.Writer, pkg string) error {
+			return rsrc.WriteGoAccessors(w, pkg, resources)
+		})
+	}
+	if err == nil && cfg.GoIDs != "" {
+		err = writeGoFile(out, cfg.GoIDs, func(w io.Writer, pkg string) error {
+			return rsrc.WriteGoIDs(w, pkg, resources)
+		})
+	}
+	if err == nil && cfg.Header != "" {
+		err = writeHeader(out, cfg.Header, resources)
+	}
+	return err
+}
+
+// allArchs are the architectures supported by Go on Windows.
+var allArchs = []string{"386", "amd64", "arm", "arm64"}
+
+// parseArchs parses a comma-separated list of architectures, or "all". An
+// empty list defaults to $GOARCH (as set by go generate), or to amd64 if
+// unset, like before -arch accepted lists.
+func parseArchs(list string) ([]string, error) {
+	switch list {
+	case "all":
+		return allArchs, nil
+	case "":
+		list = os.Getenv("GOARCH")
+		if list == "" {
+			list = "amd64"
+		}
+	}
+	archs := strings.Split(list, ",")
+	for _, arch := range archs {
+		known := false
+		for _, a := range allArchs {
+			known = known || a == arch
+		}
+		if !known {
+			return nil, fmt.Errorf("rsrc: unknown architecture '%s', expected any of: %s", arch, strings.Join(allArchs, ", "))
+		}
+	}
+	return archs, nil
+}
+
+// embed writes a COFF file with all the resources listed in files, for each
+// of archs, reading input files only once. Returns the embedded resources,
+// and the message table parsed from files.MessageTable, if any.
+func embed(out *output, fnameout string, archs []string, files rsrc.Files) ([]rsrc.Resource, *msgtable.File, error) {
+	set := rsrc.NewResourceSet()
+	defer set.Close()
+	set.SetIDRange(files.AutoIDs)
+	err := set.AddFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, w := range set.Warnings() {
+		fmt.Fprintln(os.Stderr, "rsrc: warning: "+w)
+	}
+	for _, arch := range archs {
+		fname := strings.Replace(fnameout, "{arch}", arch, -1)
+		if out.layout {
+			fields, err := set.Layout(arch)
+			if err != nil {
+				return nil, nil, err
+			}
+			printLayout(fname, fields)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		_, err := set.WriteTo(buf, arch)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = out.writeCOFF(fname, buf.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return set.Resources(), set.MessageTable(), nil
+}
+
+func writeHeader(out *output, fnameout string, resources []rsrc.Resource) error {
+	buf := &bytes.Buffer{}
+	err := rsrc.WriteCHeader(buf, resources)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(fnameout, buf.Bytes())
+}
+
+// writeGoFile creates a Go source file for the package being processed by
+// 'go generate', or package main if not run by 'go generate'. The file is not
+// modified if its contents would stay the same.
+func writeGoFile(out *output, fnameout string, write func(w io.Writer, pkg string) error) error {
+	pkg := os.Getenv("GOPACKAGE")
+	if pkg == "" {
+		pkg = "main"
+	}
+	buf := &bytes.Buffer{}
+	err := write(buf, pkg)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(fnameout, buf.Bytes())
+}
+
+// printLayout prints fields of COFF file fname to standard output, one per
+// line.
+func printLayout(fname string, fields []coff.Field) {
+	fmt.Printf("# %s\n", fname)
+	fmt.Printf("# %-8s %6s  %-52s %s\n", "OFFSET", "SIZE", "PATH", "VALUE")
+	for _, f := range fields {
+		fmt.Printf("0x%08x %6d  %-52s %s\n", f.Offset, f.Size, f.Path, f.Value)
+	}
+}
+
+// output writes generated files or, in check mode, only compares them with
+// the existing files, collecting descriptions of differences in stale. In
+// layout mode, no files are written (COFF files are printed by embed).
+type output struct {
+	check  bool
+	layout bool
+	stale  []string
+}
+
+func (o *output) writeFile(fname string, data []byte) error {
+	if o.layout {
+		return nil
+	}
+	if !o.check {
+		return internal.WriteFile(fname, data)
+	}
+	old, err := o.read(fname)
+	if err != nil || old == nil {
+		return err
+	}
+	if !bytes.Equal(old, data) {
+		o.stale = append(o.stale, fname+": differs")
+	}
+	return nil
+}
+
+// writeCOFF is like writeFile, but in check mode lists differences between
+// resources in the COFF files.
+func (o *output) writeCOFF(fname string, data []byte) error {
+	if !o.check {
+		return internal.WriteFile(fname, data)
+	}
+	old, err := o.read(fname)
+	if err != nil || old == nil || bytes.Equal(old, data) {
+		return err
+	}
+	oldres, err := coff.ReadRSRC(bytes.NewReader(old))
+	if err != nil {
+		o.stale = append(o.stale, fmt.Sprintf("%s: cannot be read: %s", fname, err))
+		return nil
+	}
+	newres, err := coff.ReadRSRC(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	diffs, err := rsrc.DiffResources(oldres, newres)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		diffs = []string{"same resources, but differs in layout or time stamps"}
+	}
+	for _, d := range diffs {
+		o.stale = append(o.stale, fname+": "+d)
+	}
+	return nil
+}
+
+// read returns contents of an existing file fname, or nil if it is missing.
+func (o *output) read(fname string) ([]byte, error) {
+	if fname == "-" {
+		return nil, fmt.Errorf("rsrc: check mode cannot compare standard output, use -o to name the checked file")
+	}
+	old, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		o.stale = append(o.stale, fname+": missing")
+		return nil, nil
 	}
+	return old, err
 }
@@ -0,0 +1,377 @@
+package rsrc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+// ConfigNames are the names of config files looked up by LoadConfig in each
+// directory, in this order; the first one found is used.
+var ConfigNames = []string{"rsrc.json", filepath.Join("winres", "rsrc.json")}
+
+// Config describes resources to embed and files to generate, as read from a
+// rsrc.json file. Keys of the JSON object are named like flags of the rsrc
+// command, with lists given as JSON arrays, e.g.:
+//
+//	{
+//		"manifest": "app.manifest",
+//		"ico": ["IDI_APP=app.ico@1"],
+//		"arch": "386,amd64,arm64",
+//		"version": {
+//			"file-version": "1.2.3",
+//			"strings": {"CompanyName": "Example Inc."},
+//			"tables": {"0415": {"FileDescription": "Przykład"}}
+//		}
+//	}
+//
+// Paths of input files are relative to the directory of the config file.
+// Paths of generated files are relative to the directory of the package.
+type Config struct {
+	Manifest     string         `json:"manifest,omitempty"`
+	Icons        []string       `json:"ico,omitempty"`
+	Bitmaps      []string       `json:"bmp,omitempty"`
+	Accelerators string         `json:"accel,omitempty"`
+	MessageTable string         `json:"mc,omitempty"`
+	HTMLDir      string         `json:"html,omitempty"`
+	DataDir      string         `json:"data,omitempty"`
+	DataInclude  []string       `json:"data-include,omitempty"`
+	DataExclude  []string       `json:"data-exclude,omitempty"`
+	IDRange      string         `json:"idrange,omitempty"`
+	Version      *VersionConfig `json:"version,omitempty"`
+	VersionFrom  string         `json:"version-from,omitempty"` // "vcs" to fill in Version from git, see VCSVersion.Info and Files
+
+	Arch       string `json:"arch,omitempty"`
+	Output     string `json:"o,omitempty"`
+	Accessors  string `json:"accessors,omitempty"`
+	Header     string `json:"header,omitempty"`
+	GoIDs      string `json:"goids,omitempty"`
+	MessageIDs string `json:"mcgo,omitempty"`
+}
+
+// VersionConfig describes a version information resource. ProductVersion
+// defaults to FileVersion. Strings are in language Lang, and Tables hold
+// strings in other languages, keyed like Translation entries, e.g. "0415"
+// or "041504b0" (see versioninfo.ParseTranslation).
+type VersionConfig struct {
+	FileVersion    string                       `json:"file-version,omitempty"`
+	ProductVersion string                       `json:"product-version,omitempty"`
+	Lang           string                       `json:"lang,omitempty"` // e.g. "0409"
+	Strings        map[string]string            `json:"strings,omitempty"`
+	Tables         map[string]map[string]string `json:"tables,omitempty"`
+	Translation    []string                     `json:"translation,omitempty"` // defaults to all the tables
+}
+
+// LoadConfig reads config files found in dir and in its parent directories,
+// up to the root of the Go module (the directory with go.mod), and merges
+// them, with values from directories closer to dir overriding the others.
+// Returns nil if no config file was found.
+func LoadConfig(dir string) (*Config, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*Config
+	for _, d := range moduleDirs(dir) {
+		c, err := readConfig(d, dir)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			configs = append(configs, c)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	merged := &Config{}
+	// configs of parent directories come last, and are overridden
+	for i := len(configs) - 1; i >= 0; i-- {
+		merged.merge(configs[i])
+	}
+	return merged, nil
+}
+
+// moduleDirs returns dir and its parent directories up to the root of the
+// module, or only dir if it is not in a module.
+func moduleDirs(dir string) []string {
+	dirs := []string{dir}
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return dirs
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dirs[:1]
+		}
+		d = parent
+		dirs = append(dirs, d)
+	}
+}
+
+// readConfig reads the first of ConfigNames found in directory d, with paths
+// of input files made relative to directory pkg. Returns nil if none is found.
+func readConfig(d, pkg string) (*Config, error) {
+	for _, name := range ConfigNames {
+		fname := filepath.Join(d, name)
+		f, err := os.Open(fname)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		c := &Config{}
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+		if err != nil {
+			return nil, fmt.Errorf("rsrc: error parsing config file '%s': %s", fname, err)
+		}
+		err = c.resolve(filepath.Dir(fname), pkg)
+		if err != nil {
+			return nil, fmt.Errorf("rsrc: error in config file '%s': %s", fname, err)
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// resolve rewrites paths of input files from relative to dir, to relative to
+// pkg.
+func (c *Config) resolve(dir, pkg string) error {
+	resolveSpec := func(s string) (string, error) {
+		if s == "" {
+			return "", nil
+		}
+		spec, err := parseFileSpec(s)
+		if err != nil {
+			return "", err
+		}
+		spec.path, err = resolvePath(spec.path, dir, pkg)
+		if err != nil {
+			return "", err
+		}
+		return spec.String(), nil
+	}
+	var err error
+	for _, p := range []*string{&c.Manifest, &c.Accelerators, &c.MessageTable} {
+		*p, err = resolveSpec(*p)
+		if err != nil {
+			return err
+		}
+	}
+	for _, list := range [][]string{c.Icons, c.Bitmaps} {
+		for i := range list {
+			list[i], err = resolveSpec(list[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range []*string{&c.HTMLDir, &c.DataDir} {
+		if *p != "" {
+			*p, err = resolvePath(*p, dir, pkg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func resolvePath(p, dir, pkg string) (string, error) {
+	p = filepath.FromSlash(p)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	return filepath.Rel(pkg, p)
+}
+
+// merge overrides values in c with non-empty values from o. Strings of
+// version information, also in each of its tables, are merged key by key.
+func (c *Config) merge(o *Config) {
+	for _, f := range []struct{ dst, src *string }{
+		{&c.Manifest, &o.Manifest},
+		{&c.Accelerators, &o.Accelerators},
+		{&c.MessageTable, &o.MessageTable},
+		{&c.HTMLDir, &o.HTMLDir},
+		{&c.DataDir, &o.DataDir},
+		{&c.IDRange, &o.IDRange},
+		{&c.VersionFrom, &o.VersionFrom},
+		{&c.Arch, &o.Arch},
+		{&c.Output, &o.Output},
+		{&c.Accessors, &o.Accessors},
+		{&c.Header, &o.Header},
+		{&c.GoIDs, &o.GoIDs},
+		{&c.MessageIDs, &o.MessageIDs},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	for _, f := range []struct{ dst, src *[]string }{
+		{&c.Icons, &o.Icons},
+		{&c.Bitmaps, &o.Bitmaps},
+		{&c.DataInclude, &o.DataInclude},
+		{&c.DataExclude, &o.DataExclude},
+	} {
+		if *f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if o.Version != nil {
+		if c.Version == nil {
+			c.Version = &VersionConfig{}
+		}
+		if o.Version.FileVersion != "" {
+			c.Version.FileVersion = o.Version.FileVersion
+		}
+		if o.Version.ProductVersion != "" {
+			c.Version.ProductVersion = o.Version.ProductVersion
+		}
+		if o.Version.Lang != "" {
+			c.Version.Lang = o.Version.Lang
+		}
+		if o.Version.Translation != nil {
+			c.Version.Translation = o.Version.Translation
+		}
+		c.Version.Strings = mergeStrings(c.Version.Strings, o.Version.Strings)
+		for key, strs := range o.Version.Tables {
+			if c.Version.Tables == nil {
+				c.Version.Tables = map[string]map[string]string{}
+			}
+			c.Version.Tables[key] = mergeStrings(c.Version.Tables[key], strs)
+		}
+	}
+}
+
+func mergeStrings(dst, src map[string]string) map[string]string {
+	for k, v := range src {
+		if dst == nil {
+			dst = map[string]string{}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// apply overrides version information in info with non-empty values from v.
+func (v *VersionConfig) apply(info *versioninfo.Info) error {
+	var err error
+	if v.FileVersion != "" {
+		info.FileVersion, err = versioninfo.ParseVersion(v.FileVersion)
+		if err != nil {
+			return err
+		}
+		info.ProductVersion = info.FileVersion
+		// let the textual versions be filled in from the new numbers
+		delete(info.Strings, "FileVersion")
+		delete(info.Strings, "ProductVersion")
+	}
+	if v.ProductVersion != "" {
+		info.ProductVersion, err = versioninfo.ParseVersion(v.ProductVersion)
+		if err != nil {
+			return err
+		}
+		delete(info.Strings, "ProductVersion")
+	}
+	if v.Lang != "" {
+		lang, err := strconv.ParseUint(v.Lang, 16, 16)
+		if err != nil || len(v.Lang) != 4 {
+			return fmt.Errorf("rsrc: bad language of version info '%s': expected 4 hex digits, e.g. 0409", v.Lang)
+		}
+		info.Lang = uint16(lang)
+	}
+	info.Strings = mergeStrings(info.Strings, v.Strings)
+	keys := make([]string, 0, len(v.Tables))
+	for k := range v.Tables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t, err := versioninfo.ParseTranslation(k)
+		if err != nil {
+			return err
+		}
+		info.Tables = append(info.Tables, versioninfo.StringTable{Translation: t, Strings: v.Tables[k]})
+	}
+	if v.Translation != nil {
+		info.Translations = []versioninfo.Translation{}
+		for _, s := range v.Translation {
+			t, err := versioninfo.ParseTranslation(s)
+			if err != nil {
+				return err
+			}
+			info.Translations = append(info.Translations, t)
+		}
+	}
+	return nil
+}
+
+// versionInfo returns version information described by c, or nil if none.
+// Values from c.Version override the ones read from VCS.
+func (c *Config) versionInfo() (*versioninfo.Info, error) {
+	info := &versioninfo.Info{}
+	switch c.VersionFrom {
+	case "":
+		if c.Version == nil {
+			return nil, nil
+		}
+	case "vcs":
+		vcs, err := ReadVCSVersion(".")
+		if err != nil {
+			return nil, err
+		}
+		info, err = vcs.Info()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("rsrc: unknown source of version '%s', expected: vcs", c.VersionFrom)
+	}
+	if c.Version != nil {
+		err := c.Version.apply(info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// Files returns the input files listed in c. Version information from VCS is
+// read from the repository containing the current directory, not the
+// directory of the config file; like paths of input files, which are
+// relative to the directory passed to LoadConfig, it assumes that this
+// directory is the current one.
+func (c *Config) Files() (Files, error) {
+	files := Files{
+		Manifest:     c.Manifest,
+		Icons:        c.Icons,
+		Bitmaps:      c.Bitmaps,
+		Accelerators: c.Accelerators,
+		MessageTable: c.MessageTable,
+		HTMLDir:      c.HTMLDir,
+		DataDir:      c.DataDir,
+		DataInclude:  c.DataInclude,
+		DataExclude:  c.DataExclude,
+	}
+	var err error
+	if c.IDRange != "" {
+		files.AutoIDs, err = ParseIDRange(c.IDRange)
+		if err != nil {
+			return files, err
+		}
+	}
+	files.VersionInfo, err = c.versionInfo()
+	if err != nil {
+		return files, err
+	}
+	return files, nil
+}
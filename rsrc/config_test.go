@@ -0,0 +1,99 @@
+package rsrc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		fname := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fname, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "m")
+	writeFiles(t, tmp, map[string]string{
+		// not in the module, must be ignored
+		"rsrc.json":       `{"arch": "arm"}`,
+		"other/empty.txt": "",
+		"m/go.mod":        "module example.com/m\n",
+		"m/rsrc.json": `{
+			"manifest": "winres/app.manifest",
+			"arch": "386,amd64",
+			"version": {"file-version": "1.0", "strings": {"CompanyName": "Example", "ProductName": "Tools"},
+				"tables": {"0415": {"CompanyName": "Przykład", "ProductName": "Narzędzia"}}}
+		}`,
+		"m/cmd/app/winres/rsrc.json": `{
+			"ico": ["IDI_APP=app.ico@1"],
+			"accessors": "rsrc_windows.go",
+			"version": {"file-version": "2.1.3", "strings": {"ProductName": "App"},
+				"tables": {"0415": {"ProductName": "Aplikacja"}}}
+		}`,
+	})
+
+	cfg, err := LoadConfig(filepath.Join(root, "cmd", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Manifest:  filepath.Join("..", "..", "winres", "app.manifest"),
+		Icons:     []string{"IDI_APP=" + filepath.Join("winres", "app.ico") + "@1"},
+		Arch:      "386,amd64",
+		Accessors: "rsrc_windows.go",
+		Version: &VersionConfig{
+			FileVersion: "2.1.3",
+			Strings:     map[string]string{"CompanyName": "Example", "ProductName": "App"},
+			Tables: map[string]map[string]string{
+				"0415": {"CompanyName": "Przykład", "ProductName": "Aplikacja"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got config:\n%+v\n%+v\nwant:\n%+v\n%+v", cfg, cfg.Version, want, want.Version)
+	}
+
+	files, err := cfg.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := files.VersionInfo; v == nil || v.FileVersion != (versioninfo.Version{2, 1, 3, 0}) || v.ProductVersion != v.FileVersion {
+		t.Errorf("got version info: %+v", v)
+	}
+	if v := files.VersionInfo; len(v.Tables) != 1 || v.Tables[0].Translation != (versioninfo.Translation{Lang: 0x0415, CodePage: versioninfo.CP_UNICODE}) {
+		t.Errorf("got string tables: %+v", v.Tables)
+	}
+
+	cfg.Version.Translation = []string{"0409", "0x415"}
+	if _, err := cfg.Files(); err == nil || !strings.Contains(err.Error(), "0x415") {
+		t.Errorf("expected error about bad translation, got: %v", err)
+	}
+
+	// outside of a module, only the directory itself is checked
+	if cfg, err := LoadConfig(filepath.Join(tmp, "other")); cfg != nil || err != nil {
+		t.Errorf("expected no config, got: %+v, %v", cfg, err)
+	}
+
+	writeFiles(t, root, map[string]string{"rsrc.json": `{"icons": ["typo.ico"]}`})
+	if _, err := LoadConfig(root); err == nil || !strings.Contains(err.Error(), "icons") {
+		t.Errorf("expected error about unknown key, got: %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package rsrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/ico"
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+// DiffResources compares two lists of resources, e.g. read with
+// coff.ReadRSRC, and describes their differences, one per line, such as
+// "RT_ICON 3 differs: 4286 vs 9662 bytes". Resources are matched by type,
+// name or ID, and language. Lines describing a changed resource are followed
+// by indented lines with details: a line diff of text resources and of
+// strings in version information, and a comparison of dimensions and bit
+// depths of icons. Returns nil if both lists hold the same resources with the
+// same contents.
+func DiffResources(a, b []coff.Resource) ([]string, error) {
+	type pair struct{ a, b *coff.Resource }
+	pairs := map[string]*pair{}
+	var keys []coff.Resource
+	get := func(r coff.Resource) *pair {
+		p := pairs[r.String()]
+		if p == nil {
+			p = &pair{}
+			pairs[r.String()] = p
+			keys = append(keys, r)
+		}
+		return p
+	}
+	for i := range a {
+		get(a[i]).a = &a[i]
+	}
+	for i := range b {
+		get(b[i]).b = &b[i]
+	}
+	sort.Slice(keys, func(i, j int) bool { return resourceLess(keys[i], keys[j]) })
+
+	var diffs []string
+	for _, key := range keys {
+		p := pairs[key.String()]
+		label := resourceLabel(key)
+		switch {
+		case p.b == nil:
+			diffs = append(diffs, fmt.Sprintf("%s removed: %d bytes", label, p.a.Data.Size()))
+		case p.a == nil:
+			diffs = append(diffs, fmt.Sprintf("%s added: %d bytes", label, p.b.Data.Size()))
+		default:
+			da, err := resourceData(*p.a)
+			if err != nil {
+				return nil, err
+			}
+			db, err := resourceData(*p.b)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case len(da) != len(db):
+				diffs = append(diffs, fmt.Sprintf("%s differs: %d vs %d bytes", label, len(da), len(db)))
+			case !bytes.Equal(da, db):
+				diffs = append(diffs, fmt.Sprintf("%s differs: same size of %d bytes, different contents", label, len(da)))
+			default:
+				continue
+			}
+			for _, d := range diffDetails(key, da, db) {
+				diffs = append(diffs, "  "+d)
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// diffDetails describes differences between contents a and b of resource r,
+// if its format is known.
+func diffDetails(r coff.Resource, a, b []byte) []string {
+	if r.TypeName != "" {
+		return nil
+	}
+	switch r.Type {
+	case coff.RT_VERSION:
+		va, erra := versioninfo.Decode(a)
+		vb, errb := versioninfo.Decode(b)
+		if erra != nil || errb != nil {
+			return nil
+		}
+		return diffLines(versionLines(va), versionLines(vb))
+	case coff.RT_ICON:
+		return []string{fmt.Sprintf("image: %s vs %s", describeIconImage(a), describeIconImage(b))}
+	case coff.RT_GROUP_ICON:
+		ea, eb := groupEntries(a), groupEntries(b)
+		var details []string
+		for i := 0; i < len(ea) || i < len(eb); i++ {
+			switch {
+			case i >= len(eb):
+				details = append(details, fmt.Sprintf("entry %d removed: %s", i+1, ea[i]))
+			case i >= len(ea):
+				details = append(details, fmt.Sprintf("entry %d added: %s", i+1, eb[i]))
+			case ea[i] != eb[i]:
+				details = append(details, fmt.Sprintf("entry %d: %s vs %s", i+1, ea[i], eb[i]))
+			}
+		}
+		return details
+	}
+	if isText(a) && isText(b) {
+		return diffLines(strings.Split(string(a), "\n"), strings.Split(string(b), "\n"))
+	}
+	return nil
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// versionLines lists fields and strings of info, one per line.
+func versionLines(info *versioninfo.Info) []string {
+	lines := []string{
+		"FileVersion " + info.FileVersion.String(),
+		"ProductVersion " + info.ProductVersion.String(),
+		fmt.Sprintf("FileFlags 0x%x", info.FileFlags),
+		fmt.Sprintf("FileType %d", info.FileType),
+	}
+	tables := append([]versioninfo.StringTable{{
+		Translation: versioninfo.Translation{Lang: info.Lang, CodePage: versioninfo.CP_UNICODE},
+		Strings:     info.Strings,
+	}}, info.Tables...)
+	for _, t := range tables {
+		keys := make([]string, 0, len(t.Strings))
+		for k := range t.Strings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			lines = append(lines, fmt.Sprintf("%s %s: %s", t.Translation, k, t.Strings[k]))
+		}
+	}
+	var translations []string
+	for _, t := range info.Translations {
+		translations = append(translations, t.String())
+	}
+	return append(lines, "Translation "+strings.Join(translations, " "))
+}
+
+// describeIconImage describes dimensions and bit depth of an image stored in
+// a RT_ICON resource, in PNG or BMP format, e.g. "32x32 32-bit".
+func describeIconImage(data []byte) string {
+	if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) && len(data) >= 26 {
+		// IHDR: width, height, bit depth, color type
+		w, h := binary.BigEndian.Uint32(data[16:]), binary.BigEndian.Uint32(data[20:])
+		channels := map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[data[25]]
+		return fmt.Sprintf("%dx%d %d-bit PNG", w, h, int(data[24])*channels)
+	}
+	var hdr ico.BITMAPINFOHEADER
+	if binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr) != nil {
+		return "unknown format"
+	}
+	// height of icons covers both the XOR and AND masks
+	return fmt.Sprintf("%dx%d %d-bit", hdr.Width, hdr.Height/2, hdr.BitCount)
+}
+
+// groupEntries describes images listed in a RT_GROUP_ICON resource.
+func groupEntries(data []byte) []string {
+	var dir ico.ICONDIR
+	r := bytes.NewReader(data)
+	if binary.Read(r, binary.LittleEndian, &dir) != nil {
+		return nil
+	}
+	var entries []string
+	for i := 0; i < int(dir.Count); i++ {
+		var e _GRPICONDIRENTRY
+		if binary.Read(r, binary.LittleEndian, &e) != nil {
+			break
+		}
+		w, h := int(e.Width), int(e.Height)
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		entries = append(entries, fmt.Sprintf("%dx%d %d-bit, ID %d", w, h, e.BitCount, e.Id))
+	}
+	return entries
+}
+
+// maxDiffCells limits the size of the table used by diffLines.
+const maxDiffCells = 1 << 20
+
+// diffLines returns lines removed from a, prefixed with "- ", and lines added
+// in b, prefixed with "+ ", based on their longest common subsequence.
+func diffLines(a, b []string) []string {
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return []string{"(too many lines to compare)"}
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
+
+// resourceLess orders resources like in the .rsrc tree: by type, then named
+// resources by name before the others by ID, then by language.
+func resourceLess(x, y coff.Resource) bool {
+	switch {
+	case x.TypeName != y.TypeName:
+		return x.TypeName != "" && (y.TypeName == "" || x.TypeName < y.TypeName)
+	case x.Type != y.Type:
+		return x.Type < y.Type
+	case x.Name != y.Name:
+		return x.Name != "" && (y.Name == "" || x.Name < y.Name)
+	case x.ID != y.ID:
+		return x.ID < y.ID
+	}
+	return x.Lang < y.Lang
+}
+
+// resourceLabel describes r like "RT_ICON 3" or "RT_HTML INDEX.HTML", with
+// the language added if it is not the default one.
+func resourceLabel(r coff.Resource) string {
+	label, ok := typeNames[r.Type]
+	switch {
+	case r.TypeName != "":
+		label = r.TypeName
+	case !ok:
+		label = fmt.Sprintf("type %d", r.Type)
+	}
+	if r.Name != "" {
+		label += " " + r.Name
+	} else {
+		label += fmt.Sprintf(" %d", r.ID)
+	}
+	if r.Lang != uint16(coff.LANG_ENTRY.NameOrId) {
+		label += fmt.Sprintf(" (language 0x%04X)", r.Lang)
+	}
+	return label
+}
+
+// resourceData returns the contents of r, without consuming its Data if it
+// is an io.ReaderAt.
+func resourceData(r coff.Resource) ([]byte, error) {
+	var rd io.Reader
+	switch d := r.Data.(type) {
+	case readerAtSizer:
+		rd = io.NewSectionReader(d, 0, d.Size())
+	case io.Reader:
+		rd = d
+	default:
+		return nil, fmt.Errorf("rsrc: data of %s cannot be read", resourceLabel(r))
+	}
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, fmt.Errorf("rsrc: error reading data of %s: %s", resourceLabel(r), err)
+	}
+	return data, nil
+}
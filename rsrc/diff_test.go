@@ -0,0 +1,96 @@
+package rsrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/ico"
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+// groupIcon returns contents of a RT_GROUP_ICON resource listing images of
+// given sizes, with 32 bits per pixel and consecutive IDs starting from 1.
+func groupIcon(sizes ...byte) string {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, ico.ICONDIR{Type: 1, Count: uint16(len(sizes))})
+	for i, size := range sizes {
+		binary.Write(buf, binary.LittleEndian, _GRPICONDIRENTRY{
+			IconDirEntryCommon: ico.IconDirEntryCommon{Width: size, Height: size, Planes: 1, BitCount: 32},
+			Id:                 uint16(i + 1),
+		})
+	}
+	return buf.String()
+}
+
+func TestDiffResources(t *testing.T) {
+	res := func(kind uint32, id uint16, name string, lang uint16, data string) coff.Resource {
+		return coff.Resource{Type: kind, ID: id, Name: name, Lang: lang, Data: strings.NewReader(data)}
+	}
+	version := func(v versioninfo.Version) string {
+		info := &versioninfo.Info{FileVersion: v, ProductVersion: v}
+		return string(info.Encode())
+	}
+	small, large := pngFile(t, 16), pngFile(t, 32)
+	a := []coff.Resource{
+		res(coff.RT_ICON, 3, "", 0x0409, string(small)),
+		res(coff.RT_ICON, 4, "", 0x0409, "removed"),
+		res(coff.RT_GROUP_ICON, 1, "", 0x0409, groupIcon(16, 0)),
+		res(coff.RT_VERSION, 1, "", 0x0409, version(versioninfo.Version{1, 0, 0, 0})),
+		res(coff.RT_HTML, 0, "INDEX.HTML", 0x0409, "<html>"),
+		res(coff.RT_MANIFEST, 1, "", 0x0409, "<assembly>\n<asInvoker/>\n</assembly>"),
+	}
+	b := []coff.Resource{
+		res(coff.RT_MANIFEST, 1, "", 0x0409, "<assembly>\n<requireAdministrator/>\n</assembly>"),
+		res(coff.RT_VERSION, 1, "", 0x0409, version(versioninfo.Version{1, 1, 0, 0})),
+		res(coff.RT_HTML, 0, "INDEX.HTML", 0x0409, "<HTML>"),
+		res(coff.RT_GROUP_ICON, 1, "", 0x0409, groupIcon(32, 0, 48)),
+		res(coff.RT_ICON, 3, "", 0x0409, string(large)),
+		res(coff.RT_HTML, 2, "", 0x0409, "<p>"),
+		res(77, 1, "", 0x0415, "custom"),
+		{TypeName: "MUI", ID: 1, Lang: 0x0409, Data: strings.NewReader("mui")},
+	}
+	got, err := DiffResources(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"MUI 1 added: 3 bytes",
+		fmt.Sprintf("RT_ICON 3 differs: %d vs %d bytes", len(small), len(large)),
+		"  image: 16x16 32-bit PNG vs 32x32 32-bit PNG",
+		"RT_ICON 4 removed: 7 bytes",
+		"RT_GROUP_ICON 1 differs: 34 vs 48 bytes",
+		"  entry 1: 16x16 32-bit, ID 1 vs 32x32 32-bit, ID 1",
+		"  entry 3 added: 48x48 32-bit, ID 3",
+		"RT_VERSION 1 differs: same size of 320 bytes, different contents",
+		"  - FileVersion 1.0.0.0",
+		"  - ProductVersion 1.0.0.0",
+		"  + FileVersion 1.1.0.0",
+		"  + ProductVersion 1.1.0.0",
+		"  - 040904b0 FileVersion: 1.0.0.0",
+		"  - 040904b0 ProductVersion: 1.0.0.0",
+		"  + 040904b0 FileVersion: 1.1.0.0",
+		"  + 040904b0 ProductVersion: 1.1.0.0",
+		"RT_HTML INDEX.HTML differs: same size of 6 bytes, different contents",
+		"  - <html>",
+		"  + <HTML>",
+		"RT_HTML 2 added: 3 bytes",
+		"RT_MANIFEST 1 differs: 35 vs 46 bytes",
+		"  - <asInvoker/>",
+		"  + <requireAdministrator/>",
+		"type 77 1 (language 0x0415) added: 6 bytes",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got, err := DiffResources(a[5:], b[:1]); len(got) != 3 || err != nil {
+		t.Errorf("expected only the manifest to differ, got: %q, %v", got, err)
+	}
+	if got, err := DiffResources(a[4:5], a[4:5]); got != nil || err != nil {
+		t.Errorf("expected no differences, got: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,180 @@
+package rsrc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+// DataIndexName is the name of the RT_RCDATA resource with an index of files
+// embedded from Files.DataDir. The index is UTF-8 text, with one line per
+// file, sorted by path. Each line has tab-separated fields:
+//
+//	PATH	RESOURCE_NAME	SIZE	SHA256
+//
+// where PATH is relative to DataDir, with '/' separators, and SHA256 is
+// hex-encoded.
+const DataIndexName = "RSRC_INDEX"
+
+type dirFile struct {
+	path string // as passed to embedder.open
+	rel  string // relative to walked directory, with '/' separators
+	name string // resource name
+	size int64
+}
+
+// dirFiles lists files found recursively in dir, sorted by their relative
+// path. Patterns are matched as in path.Match against the relative path if
+// they contain a '/', otherwise against the base name of the file.
+func (e *embedder) dirFiles(dir string, include, exclude []string) ([]dirFile, error) {
+	for _, p := range append(include[:len(include):len(include)], exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("rsrc: bad pattern '%s' of files in '%s': %s", p, dir, err)
+		}
+	}
+	var fsys fs.FS
+	var join func(rel string) string
+	if e.fsys != nil {
+		var err error
+		fsys, err = fs.Sub(e.fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+		join = func(rel string) string { return path.Join(dir, rel) }
+	} else {
+		fsys = os.DirFS(dir)
+		join = func(rel string) string { return filepath.Join(dir, filepath.FromSlash(rel)) }
+	}
+
+	var files []dirFile
+	err := fs.WalkDir(fsys, ".", func(rel string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if len(include) > 0 && !matchAny(include, rel) || matchAny(exclude, rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, dirFile{
+			path: join(rel),
+			rel:  rel,
+			name: strings.ToUpper(rel),
+			size: info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	return files, nil
+}
+
+// matchAny reports if rel matches any of patterns, which must have been
+// checked by dirFiles.
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		s := rel
+		if !strings.Contains(p, "/") {
+			s = path.Base(rel)
+		}
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addHTMLDir adds all files found in directory dir as RT_HTML resources,
+// named by their base names in uppercase, as expected by the res:// protocol
+// (e.g. "res://app.exe/logo.png" loads "LOGO.PNG", found as "img/logo.png").
+// URLs of res:// have no room for directories, as in
+// "res://app.exe/img/logo.png" the "img" is taken as the resource type, so
+// files with the same base name in different directories are rejected.
+func (e *embedder) addHTMLDir(dir string) error {
+	files, err := e.dirFiles(dir, nil, nil)
+	if err != nil {
+		return err
+	}
+	seen := map[string]string{}
+	for _, file := range files {
+		file.name = strings.ToUpper(path.Base(file.rel))
+		if prev, ok := seen[file.name]; ok {
+			return fmt.Errorf("rsrc: HTML files '%s' and '%s' would both be named %s, as res:// only addresses files by base name", prev, file.path, file.name)
+		}
+		seen[file.name] = file.path
+		f, err := e.open(file.path)
+		if err != nil {
+			return fmt.Errorf("rsrc: error opening HTML file '%s': %s", file.path, err)
+		}
+		e.closers = append(e.closers, f)
+		err = e.addNamed(coff.RT_HTML, file.name, file.path, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDataDir adds files found in directory dir as RT_RCDATA resources, named
+// by their paths relative to dir, in uppercase (e.g. "IMG/LOGO.PNG"), plus an
+// index resource named DataIndexName.
+func (e *embedder) addDataDir(dir string, include, exclude []string) error {
+	files, err := e.dirFiles(dir, include, exclude)
+	if err != nil {
+		return err
+	}
+	var index bytes.Buffer
+	seen := map[string]string{}
+	for _, file := range files {
+		if file.name == DataIndexName {
+			return fmt.Errorf("rsrc: file '%s' collides with the name of index resource %s", file.path, DataIndexName)
+		}
+		if prev, ok := seen[file.name]; ok {
+			return fmt.Errorf("rsrc: data files '%s' and '%s' would both be named %s, as names are case-insensitive", prev, file.path, file.name)
+		}
+		seen[file.name] = file.path
+		sum, err := e.hashFile(file.path)
+		if err != nil {
+			return fmt.Errorf("rsrc: error reading data file '%s': %s", file.path, err)
+		}
+		fmt.Fprintf(&index, "%s\t%s\t%d\t%x\n", file.rel, file.name, file.size, sum)
+
+		f, err := e.open(file.path)
+		if err != nil {
+			return fmt.Errorf("rsrc: error opening data file '%s': %s", file.path, err)
+		}
+		e.closers = append(e.closers, f)
+		err = e.addNamed(coff.RT_RCDATA, file.name, file.path, f)
+		if err != nil {
+			return err
+		}
+	}
+	return e.addNamed(coff.RT_RCDATA, DataIndexName, dir, bytes.NewReader(index.Bytes()))
+}
+
+func (e *embedder) hashFile(fname string) ([]byte, error) {
+	f, err := e.open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,219 @@
+package rsrc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel      string
+		want     bool
+	}{
+		{[]string{"*.png"}, "logo.png", true},
+		{[]string{"*.png"}, "img/logo.png", true}, // base name, in any directory
+		{[]string{"img/*"}, "img/logo.png", true},
+		{[]string{"img/*"}, "img/icons/app.png", false},
+		{[]string{"img/*"}, "other/img/logo.png", false}, // path relative to the directory
+		{[]string{"*/*.png"}, "img/logo.png", true},
+		{[]string{"*.txt", "*.png"}, "logo.png", true},
+		{[]string{"*.txt"}, "logo.png", false},
+		{[]string{"[bad"}, "[bad", false},
+		{nil, "logo.png", false},
+	}
+	for _, tt := range tests {
+		if got := matchAny(tt.patterns, tt.rel); got != tt.want {
+			t.Errorf("matchAny(%q, %q) = %v, want %v", tt.patterns, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	files := map[string]string{
+		"assets/config.json":       "{}",
+		"assets/img/logo.png":      "png",
+		"assets/img/old.png.bak":   "bak",
+		"assets/img/icons/app.png": "icon",
+		"assets/nested/deep/a.txt": "aaa",
+		"assets/nested/deep/b.md":  "bbb",
+		"assets/notes.md":          "notes",
+		"other/outside.json":       "{}",
+	}
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	hash := func(rel string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(files["assets/"+rel])))
+	}
+	line := func(rel string) string {
+		return fmt.Sprintf("%s\t%s\t%d\t%s", rel, strings.ToUpper(rel), len(files["assets/"+rel]), hash(rel))
+	}
+
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             []string
+	}{{
+		name: "all",
+		want: []string{"config.json", "img/icons/app.png", "img/logo.png", "img/old.png.bak", "nested/deep/a.txt", "nested/deep/b.md", "notes.md"},
+	}, {
+		name:    "include",
+		include: []string{"*.png", "nested/deep/*"},
+		want:    []string{"img/icons/app.png", "img/logo.png", "nested/deep/a.txt", "nested/deep/b.md"},
+	}, {
+		name:    "exclude",
+		exclude: []string{"*.bak", "img/icons/*", "*.md"},
+		want:    []string{"config.json", "img/logo.png", "nested/deep/a.txt"},
+	}, {
+		name:    "include and exclude",
+		include: []string{"img/*", "*.txt"},
+		exclude: []string{"*.bak"},
+		want:    []string{"img/logo.png", "nested/deep/a.txt"},
+	}}
+	for _, tt := range tests {
+		for _, order := range []struct {
+			name string
+			fsys fs.FS
+		}{{"sorted", fsys}, {"reversed", reversedFS{fsys}}} {
+			t.Run(tt.name+"/"+order.name, func(t *testing.T) {
+				set := NewResourceSet()
+				defer set.Close()
+				set.SetFS(order.fsys)
+				err := set.AddFiles(Files{DataDir: "assets", DataInclude: tt.include, DataExclude: tt.exclude})
+				if err != nil {
+					t.Fatal(err)
+				}
+				buf := &bytes.Buffer{}
+				if _, err := set.WriteTo(buf, "amd64"); err != nil {
+					t.Fatal(err)
+				}
+				resources, err := coff.ReadRSRC(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data := map[string]string{}
+				for _, r := range resources {
+					if r.Type != coff.RT_RCDATA || r.Name == "" {
+						t.Errorf("unexpected resource %s", r)
+						continue
+					}
+					b, err := ioutil.ReadAll(r.Data.(io.Reader))
+					if err != nil {
+						t.Fatal(err)
+					}
+					data[r.Name] = string(b)
+				}
+
+				var want []string
+				for _, rel := range tt.want {
+					want = append(want, line(rel))
+					if got := data[strings.ToUpper(rel)]; got != files["assets/"+rel] {
+						t.Errorf("resource %s: got %q, want contents of %s", strings.ToUpper(rel), got, rel)
+					}
+				}
+				index := strings.TrimSuffix(data[DataIndexName], "\n")
+				if index != strings.Join(want, "\n") {
+					t.Errorf("got index:\n%s\nwant:\n%s", index, strings.Join(want, "\n"))
+				}
+				if len(resources) != len(tt.want)+1 {
+					t.Errorf("got %d resources, want %d files and the index", len(resources), len(tt.want))
+				}
+
+				// files are added in order of paths, whatever the order of
+				// walking the directory
+				var names []string
+				for _, r := range set.Resources() {
+					names = append(names, r.Name)
+				}
+				var wantNames []string
+				for _, rel := range tt.want {
+					wantNames = append(wantNames, strings.ToUpper(rel))
+				}
+				wantNames = append(wantNames, DataIndexName)
+				if strings.Join(names, " ") != strings.Join(wantNames, " ") {
+					t.Errorf("got resources in order: %s\nwant: %s", strings.Join(names, " "), strings.Join(wantNames, " "))
+				}
+			})
+		}
+	}
+
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetFS(fstest.MapFS{"assets/rsrc_index": {Data: []byte("x")}})
+	err := set.AddFiles(Files{DataDir: "assets"})
+	if err == nil || !strings.Contains(err.Error(), "collides with the name of index resource") {
+		t.Errorf("expected error about file colliding with the index, got: %v", err)
+	}
+}
+
+func TestHTMLDirNames(t *testing.T) {
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetFS(fstest.MapFS{
+		"web/index.html":   {Data: []byte("<html/>")},
+		"web/img/logo.png": {Data: []byte("png")},
+	})
+	if err := set.AddFiles(Files{HTMLDir: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range set.Resources() {
+		got = append(got, fmt.Sprintf("%s %s", r.Name, r.File))
+	}
+	// res://app.exe/logo.png, as in res://app.exe/img/logo.png "IMG" would
+	// be taken as the resource type
+	want := "LOGO.PNG web/img/logo.png, INDEX.HTML web/index.html"
+	if s := strings.Join(got, ", "); s != want {
+		t.Errorf("got resources: %s\nwant: %s", s, want)
+	}
+
+	set = NewResourceSet()
+	defer set.Close()
+	set.SetFS(fstest.MapFS{
+		"web/logo.png":     {Data: []byte("png")},
+		"web/img/logo.png": {Data: []byte("png")},
+	})
+	err := set.AddFiles(Files{HTMLDir: "web"})
+	if err == nil || !strings.Contains(err.Error(), "would both be named LOGO.PNG") {
+		t.Errorf("expected error about colliding names, got: %v", err)
+	}
+}
+
+func TestDataDirCollisions(t *testing.T) {
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetFS(fstest.MapFS{
+		"data/Readme.txt": {Data: []byte("a")},
+		"data/README.TXT": {Data: []byte("b")},
+	})
+	err := set.AddFiles(Files{DataDir: "data"})
+	if want := "data files 'data/README.TXT' and 'data/Readme.txt' would both be named README.TXT"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error %q, got: %v", want, err)
+	}
+}
+
+func TestDataDirBadPatterns(t *testing.T) {
+	for _, files := range []Files{
+		{DataDir: "data", DataInclude: []string{"*.txt", "["}},
+		{DataDir: "data", DataExclude: []string{"img/[a-"}},
+	} {
+		set := NewResourceSet()
+		defer set.Close()
+		set.SetFS(fstest.MapFS{"data/a.txt": {Data: []byte("a")}})
+		err := set.AddFiles(files)
+		if err == nil || !strings.Contains(err.Error(), "bad pattern") {
+			t.Errorf("include %q, exclude %q: expected error about bad pattern, got: %v", files.DataInclude, files.DataExclude, err)
+		}
+	}
+}
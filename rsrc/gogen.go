@@ -0,0 +1,290 @@
+package rsrc
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"text/template"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+var typeNames = map[uint32]string{
+	coff.RT_BITMAP:       "RT_BITMAP",
+	coff.RT_ICON:         "RT_ICON",
+	coff.RT_ACCELERATOR:  "RT_ACCELERATOR",
+	coff.RT_RCDATA:       "RT_RCDATA",
+	coff.RT_MESSAGETABLE: "RT_MESSAGETABLE",
+	coff.RT_GROUP_ICON:   "RT_GROUP_ICON",
+	coff.RT_VERSION:      "RT_VERSION",
+	coff.RT_HTML:         "RT_HTML",
+	coff.RT_MANIFEST:     "RT_MANIFEST",
+}
+
+// WriteGoAccessors writes Go source code of package pkg, meant to be saved
+// in a file named like rsrc_windows.go. The code declares typed constants
+// with IDs of resources that have a Symbol, and functions reading the
+// resources back at runtime from the executable, via FindResourceW,
+// LoadResource and LockResource.
+func WriteGoAccessors(w io.Writer, pkg string, resources []Resource) error {
+	data := struct {
+		Package   string
+		Resources []Resource
+		Types     map[uint32]string
+		HasData   bool
+		IndexName string
+	}{
+		Package:   pkg,
+		Resources: resources,
+		Types:     typeNames,
+		IndexName: DataIndexName,
+	}
+	for _, r := range resources {
+		if r.Type == coff.RT_RCDATA && r.Name == DataIndexName {
+			data.HasData = true
+		}
+	}
+
+	var buf bytes.Buffer
+	err := goAccessorsTemplate.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("rsrc: error formatting Go code: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+var goAccessorsTemplate = template.Must(template.New("").Parse(`// Code generated by rsrc; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .HasData}}
+	"bytes"
+	"bufio"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+{{- end}}
+	"syscall"
+	"unsafe"
+)
+
+// ResourceType is a type of a Windows resource, e.g. RT_ICON.
+type ResourceType uint16
+
+// Resource types.
+const (
+{{- range $id, $name := .Types}}
+	{{$name}} ResourceType = {{$id}}
+{{- end}}
+)
+
+// ResourceID is a numeric ID of a Windows resource.
+type ResourceID uint16
+
+// IDs of embedded resources.
+const (
+{{- range .Resources}}{{if .Symbol}}
+	{{.Symbol}} ResourceID = {{.ID}} // {{index $.Types .Type}}, from {{printf "%q" .File}}
+{{- end}}{{end}}
+)
+
+var (
+	modkernel32        = syscall.NewLazyDLL("kernel32.dll")
+	procFindResourceW  = modkernel32.NewProc("FindResourceW")
+	procSizeofResource = modkernel32.NewProc("SizeofResource")
+	procLoadResource   = modkernel32.NewProc("LoadResource")
+	procLockResource   = modkernel32.NewProc("LockResource")
+)
+
+// ResourceBytes returns a copy of the contents of a resource embedded in the
+// executable.
+func ResourceBytes(typ ResourceType, id ResourceID) ([]byte, error) {
+	hrsrc, _, err := procFindResourceW.Call(0, uintptr(id), uintptr(typ))
+	return loadResource(hrsrc, err)
+}
+
+// ResourceString returns the contents of a resource embedded in the
+// executable, as a string.
+func ResourceString(typ ResourceType, id ResourceID) (string, error) {
+	buf, err := ResourceBytes(typ, id)
+	return string(buf), err
+}
+
+// NamedResourceBytes returns a copy of the contents of a named resource
+// embedded in the executable.
+func NamedResourceBytes(typ ResourceType, name string) ([]byte, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	hrsrc, _, err := procFindResourceW.Call(0, uintptr(unsafe.Pointer(p)), uintptr(typ))
+	return loadResource(hrsrc, err)
+}
+
+// loadResource returns a copy of the contents of a resource found with
+// FindResourceW, or err if it was not found.
+func loadResource(hrsrc uintptr, err error) ([]byte, error) {
+	if hrsrc == 0 {
+		return nil, err
+	}
+	size, _, _ := procSizeofResource.Call(0, hrsrc)
+	if size == 0 {
+		return []byte{}, nil
+	}
+	hglobal, _, err := procLoadResource.Call(0, hrsrc)
+	if hglobal == 0 {
+		return nil, err
+	}
+	ptr, _, err := procLockResource.Call(hglobal)
+	if ptr == 0 {
+		return nil, err
+	}
+	// resources are mapped read-only together with the executable, outside
+	// of Go heap, so it is safe to convert the pointer here
+	mem := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), size)
+	return append([]byte(nil), mem...), nil
+}
+{{- if .HasData}}
+
+// DataFS returns a read-only view of the files embedded as RT_RCDATA from a
+// directory, based on the {{.IndexName}} resource.
+func DataFS() (fs.FS, error) {
+	index, err := NamedResourceBytes(RT_RCDATA, {{printf "%q" .IndexName}})
+	if err != nil {
+		return nil, err
+	}
+	fsys := dataFS{}
+	scanner := bufio.NewScanner(bytes.NewReader(index))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 {
+			return nil, fs.ErrInvalid
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		fsys[fields[0]] = dataFile{name: fields[1], size: size}
+	}
+	return fsys, scanner.Err()
+}
+
+// dataFS maps file paths to resource names.
+type dataFS map[string]dataFile
+
+type dataFile struct {
+	name string
+	size int64
+}
+
+func (fsys dataFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if f, ok := fsys[name]; ok {
+		buf, err := NamedResourceBytes(RT_RCDATA, f.name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &dataReader{Reader: bytes.NewReader(buf), info: dataInfo{path.Base(name), f.size, false}}, nil
+	}
+
+	// directories are implied by paths of files
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+	for p, f := range fsys {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := p[len(prefix):]
+		base, isDir := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			base, isDir = rest[:i], true
+		}
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		info := dataInfo{base, f.size, isDir}
+		if isDir {
+			info.size = 0
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return &dataDir{info: dataInfo{path.Base(name), 0, true}, entries: entries}, nil
+}
+
+type dataInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (i dataInfo) Name() string       { return i.name }
+func (i dataInfo) Size() int64        { return i.size }
+func (i dataInfo) ModTime() time.Time { return time.Time{} }
+func (i dataInfo) IsDir() bool        { return i.isDir }
+func (i dataInfo) Sys() interface{}   { return nil }
+func (i dataInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type dataReader struct {
+	*bytes.Reader
+	info dataInfo
+}
+
+func (f *dataReader) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *dataReader) Close() error               { return nil }
+
+type dataDir struct {
+	info    dataInfo
+	entries []fs.DirEntry
+}
+
+func (d *dataDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dataDir) Close() error               { return nil }
+func (d *dataDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *dataDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+{{- end}}
+`))
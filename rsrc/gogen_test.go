@@ -0,0 +1,109 @@
+package rsrc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+func TestWriteGoAccessors(t *testing.T) {
+	resources := []Resource{
+		{Type: coff.RT_MANIFEST, ID: 1, Symbol: "IDR_MANIFEST", File: "app.manifest"},
+		{Type: coff.RT_ICON, ID: 2, File: "icons/app.ico"},
+		{Type: coff.RT_GROUP_ICON, ID: 3, Symbol: "IDI_APP", File: "icons/app.ico"},
+	}
+	data := append(resources[:len(resources):len(resources)],
+		Resource{Type: coff.RT_RCDATA, Name: "FILE_0", File: "data/a.txt"},
+		Resource{Type: coff.RT_RCDATA, Name: DataIndexName, File: "data"},
+	)
+	tests := []struct {
+		name      string
+		resources []Resource
+		want      []string
+		notWant   []string
+	}{{
+		name:      "ids",
+		resources: resources,
+		want: []string{
+			"// Code generated by rsrc; DO NOT EDIT.\n\npackage main\n",
+			"\tRT_GROUP_ICON   ResourceType = 14\n",
+			"\tIDR_MANIFEST ResourceID = 1 // RT_MANIFEST, from \"app.manifest\"\n" +
+				"\tIDI_APP      ResourceID = 3 // RT_GROUP_ICON, from \"icons/app.ico\"\n)\n",
+			// pointers are converted to uintptr only in arguments of Call,
+			// which keeps them alive until the call returns
+			"\thrsrc, _, err := procFindResourceW.Call(0, uintptr(id), uintptr(typ))\n",
+			"\thrsrc, _, err := procFindResourceW.Call(0, uintptr(unsafe.Pointer(p)), uintptr(typ))\n",
+			"\tmem := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), size)\n",
+		},
+		notWant: []string{"func DataFS", "\"io/fs\"", "1 << 30"},
+	}, {
+		name:      "data",
+		resources: data,
+		want: []string{
+			"\t\"io/fs\"\n",
+			"func DataFS() (fs.FS, error) {\n\tindex, err := NamedResourceBytes(RT_RCDATA, \"RSRC_INDEX\")\n",
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := WriteGoAccessors(buf, "main", tt.resources); err != nil {
+				t.Fatal(err)
+			}
+			src := buf.String()
+			for _, s := range tt.want {
+				if !strings.Contains(src, s) {
+					t.Errorf("generated code does not contain:\n%s", s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(src, s) {
+					t.Errorf("generated code contains %q", s)
+				}
+			}
+			if t.Failed() {
+				t.Logf("generated code:\n%s", src)
+			}
+			buildWindows(t, buf.Bytes())
+		})
+	}
+}
+
+// buildWindows cross-compiles generated source of package main, together with
+// a main function using all of its exported identifiers, for 32- and 64-bit
+// Windows.
+func buildWindows(t *testing.T, src []byte) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping builds in short mode")
+	}
+	dir, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mainSrc := "package main\n\nfunc main() {\n\tResourceBytes(RT_MANIFEST, 1)\n\tResourceString(RT_MANIFEST, 1)\n\tNamedResourceBytes(RT_HTML, \"INDEX.HTML\")\n"
+	if bytes.Contains(src, []byte("func DataFS")) {
+		mainSrc += "\tDataFS()\n"
+	}
+	mainSrc += "}\n"
+	files := map[string]string{
+		"go.mod":          "module app\n\ngo 1.18\n",
+		"main.go":         mainSrc,
+		"rsrc_windows.go": string(src),
+	}
+	writeFiles(t, dir, files)
+	for _, arch := range []string{"386", "amd64"} {
+		cmd := exec.Command("go", "build", "-o", os.DevNull)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOOS=windows", "GOARCH="+arch, "CGO_ENABLED=0", "GOFLAGS=")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("building for windows/%s: %v\n%s", arch, err, out)
+		}
+	}
+}
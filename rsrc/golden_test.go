@@ -0,0 +1,330 @@
+package rsrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/ico"
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata/golden with the current output")
+
+// maxGoldenDiffs is the number of differing fields listed for a golden file.
+var maxGoldenDiffs = 20
+
+// TestGolden compares COFF files written for various sets of resources, and
+// one built directly with package coff, with golden files, byte for byte.
+// Run 'go test ./rsrc -run TestGolden -update' to rewrite the golden files
+// after an intended change of output.
+func TestGolden(t *testing.T) {
+	manifest, err := ioutil.ReadFile(filepath.Join("..", "testdata", "manifest.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon, err := ioutil.ReadFile(filepath.Join("..", "testdata", "akavel.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"app.manifest":   {Data: manifest},
+		"empty.manifest": {Data: []byte{}},
+		"akavel.ico":     {Data: icon},
+		"unaligned.ico":  {Data: unalignedIcoFile()},
+	}
+	for i := 0; i < 20; i++ {
+		fsys[fmt.Sprintf("html/page%02d.html", i)] = &fstest.MapFile{Data: bytes.Repeat([]byte("<p>"), i)}
+	}
+
+	tests := []struct {
+		name  string
+		arch  string
+		files Files
+		add   func(set *ResourceSet) error
+		coff  func() (*coff.Coff, error) // if set, used instead of a ResourceSet
+	}{
+		{name: "manifest", arch: "amd64", files: Files{Manifest: "app.manifest"}},
+		{name: "icon", arch: "amd64", files: Files{Icons: []string{"akavel.ico"}}},
+		{name: "icons", arch: "amd64", files: Files{Manifest: "app.manifest", Icons: []string{"akavel.ico", "unaligned.ico"}}},
+		// images of odd sizes, at odd offsets, as in syncthing.ico (issue #26)
+		{name: "unaligned", arch: "amd64", files: Files{Icons: []string{"unaligned.ico"}}},
+		{name: "arch_386", arch: "386", files: Files{Manifest: "app.manifest", Icons: []string{"unaligned.ico"}}},
+		{name: "arch_amd64", arch: "amd64", files: Files{Manifest: "app.manifest", Icons: []string{"unaligned.ico"}}},
+		{name: "arch_arm", arch: "arm", files: Files{Manifest: "app.manifest", Icons: []string{"unaligned.ico"}}},
+		{name: "arch_arm64", arch: "arm64", files: Files{Manifest: "app.manifest", Icons: []string{"unaligned.ico"}}},
+		{
+			name: "many",
+			arch: "amd64",
+			files: Files{
+				Manifest:    "app.manifest",
+				Icons:       []string{"unaligned.ico"},
+				HTMLDir:     "html",
+				VersionInfo: &versioninfo.Info{FileVersion: versioninfo.Version{1, 2, 3, 4}, Strings: map[string]string{"ProductName": "Golden"}},
+			},
+			add: func(set *ResourceSet) error {
+				for i := 0; i < 200; i++ {
+					if i == 100 {
+						set.SetLanguage(0x0415)
+					}
+					err := set.AddData(coff.RT_RCDATA, fmt.Sprintf("data%d@%d", i, 1000+i), bytes.Repeat([]byte{byte(i)}, i%9))
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{name: "empty", arch: "amd64"},
+		{
+			name:  "empty_data",
+			arch:  "amd64",
+			files: Files{Manifest: "empty.manifest"},
+			add: func(set *ResourceSet) error {
+				return set.AddData(coff.RT_RCDATA, "empty@2", nil)
+			},
+		},
+		{
+			name: "coff",
+			coff: func() (*coff.Coff, error) {
+				out := coff.NewRSRC()
+				err := out.Arch("386")
+				if err != nil {
+					return nil, err
+				}
+				// added out of order, to be sorted by Freeze
+				for _, r := range []struct {
+					kind uint32
+					name string
+					id   uint16
+					lang uint16
+					data string
+				}{
+					{coff.RT_RCDATA, "", 300, 0x0415, "data in Polish"},
+					{coff.RT_RCDATA, "", 300, 0x0409, "data"},
+					{coff.RT_HTML, "STYLE.CSS", 0, 0x0409, "p{}"},
+					{coff.RT_MANIFEST, "", 1, 0x0409, string(manifest)},
+					{coff.RT_HTML, "INDEX.HTML", 0, 0x0409, "<html/>"},
+					{coff.RT_RCDATA, "", 2, 0x0409, ""},
+				} {
+					if r.name != "" {
+						err = out.AddNamedResourceLang(r.kind, r.name, r.lang, strings.NewReader(r.data))
+					} else {
+						err = out.AddResourceLang(r.kind, r.id, r.lang, strings.NewReader(r.data))
+					}
+					if err != nil {
+						return nil, err
+					}
+				}
+				out.SetTimeDateStamp(1700000000)
+				out.Freeze()
+				return out, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if tt.coff != nil {
+				out, err := tt.coff()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := out.WriteTo(buf); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				set := NewResourceSet()
+				defer set.Close()
+				set.SetFS(fsys)
+				set.SetTimestamp(time.Unix(1700000000, 0))
+				if err := set.AddFiles(tt.files); err != nil {
+					t.Fatal(err)
+				}
+				if tt.add != nil {
+					if err := tt.add(set); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := set.WriteTo(buf, tt.arch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := buf.Bytes()
+			if problems := coff.Verify(got); problems != nil {
+				t.Errorf("problems found in output:\n%s", strings.Join(problems, "\n"))
+			}
+
+			fname := filepath.Join("testdata", "golden", tt.name+".syso")
+			if *update {
+				if err := ioutil.WriteFile(fname, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatalf("%v (run 'go test ./rsrc -run TestGolden -update' to create golden files)", err)
+			}
+			if bytes.Equal(got, want) {
+				return
+			}
+			t.Errorf("output differs from %s (run 'go test ./rsrc -run TestGolden -update' if intended):\n%s", fname, strings.Join(goldenDiff(got, want), "\n"))
+		})
+	}
+}
+
+// goldenDiff describes differences between output got and golden file want,
+// as fields which differ, are missing or were added, matched by their paths
+// in layouts read from both files. This way, a field inserted in the output
+// is listed alone, instead of all the fields after it, which only moved.
+func goldenDiff(got, want []byte) []string {
+	var diffs []string
+	if len(got) != len(want) {
+		diffs = append(diffs, fmt.Sprintf("file size: got %d bytes, golden %d bytes", len(got), len(want)))
+	}
+	gotFields, _ := coff.ReadLayout(got)
+	wantFields, _ := coff.ReadLayout(want)
+	golden := map[string]coff.Field{}
+	for _, f := range wantFields {
+		golden[f.Path] = f
+	}
+	n := 0
+	add := func(format string, args ...interface{}) {
+		n++
+		if n <= maxGoldenDiffs {
+			diffs = append(diffs, fmt.Sprintf(format, args...))
+		}
+	}
+	found := map[string]bool{}
+	for _, f := range gotFields {
+		if f.Path == "(unused)" {
+			continue
+		}
+		found[f.Path] = true
+		w, ok := golden[f.Path]
+		if !ok {
+			add("%s at 0x%x: got %s, missing in golden file", f.Path, f.Offset, fieldValue(fieldData(got, f)))
+			continue
+		}
+		g, wd := fieldData(got, f), fieldData(want, w)
+		switch {
+		case bytes.Equal(g, wd):
+		case len(g) != len(wd):
+			add("%s at 0x%x: got %d bytes, golden %d bytes at 0x%x", f.Path, f.Offset, len(g), len(wd), w.Offset)
+		case len(g) > 4:
+			i := 0
+			for g[i] == wd[i] {
+				i++
+			}
+			add("%s at 0x%x: byte %d of %d differs, got 0x%02x, golden 0x%02x", f.Path, f.Offset, i, len(g), g[i], wd[i])
+		default:
+			add("%s at 0x%x: got %s, golden %s", f.Path, f.Offset, fieldValue(g), fieldValue(wd))
+		}
+	}
+	for _, w := range wantFields {
+		if w.Path != "(unused)" && !found[w.Path] {
+			add("%s at 0x%x of golden file: golden %s, missing in output", w.Path, w.Offset, fieldValue(fieldData(want, w)))
+		}
+	}
+	if n > maxGoldenDiffs {
+		diffs = append(diffs, fmt.Sprintf("... and %d more fields", n-maxGoldenDiffs))
+	}
+	if n == 0 {
+		diffs = append(diffs, "no fields differ, only bytes not covered by any field")
+	}
+	return diffs
+}
+
+func fieldData(data []byte, f coff.Field) []byte {
+	return data[f.Offset : f.Offset+f.Size]
+}
+
+func TestGoldenDiff(t *testing.T) {
+	write := func(manifests ...string) []byte {
+		out := coff.NewRSRC()
+		for i, m := range manifests {
+			if err := out.AddResource(coff.RT_MANIFEST, uint16(i+1), strings.NewReader(m)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out.Freeze()
+		buf := &bytes.Buffer{}
+		if _, err := out.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	want := write("<assembly/>")
+	got := write("<assembly/>", "<assembly>2</assembly>")
+	defer func(n int) { maxGoldenDiffs = n }(maxGoldenDiffs)
+	maxGoldenDiffs = 1000
+	diffs := strings.Join(goldenDiff(got, want), "\n") + "\n"
+	for _, s := range []string{
+		"/SectionHeader32/NumberOfRelocations at 0x34: got 0x0002, golden 0x0001\n",
+		"/Dir/Dirs[0]/DirEntries[1]/NameOrId at ",
+		"/Data[1]/Data at 0xf4: got \"<assembly>2</ass\"..., missing in golden file\n",
+	} {
+		if !strings.Contains(diffs, s) {
+			t.Errorf("expected %q in diffs, got:\n%s", s, diffs)
+		}
+	}
+	// moved, but not changed
+	if strings.Contains(diffs, "/Data[0]/Data") {
+		t.Errorf("expected data of the first manifest to match, got:\n%s", diffs)
+	}
+	diffs = strings.Join(goldenDiff(want, got), "\n")
+	if s := "/Dir/Dirs[0]/DirEntries[1]/NameOrId at 0x6c of golden file: golden 0x00000002, missing in output"; !strings.Contains(diffs, s) {
+		t.Errorf("expected %q in diffs, got:\n%s", s, diffs)
+	}
+}
+
+// fieldValue formats data of a field like coff.Field.Value.
+func fieldValue(data []byte) string {
+	switch len(data) {
+	case 1:
+		return fmt.Sprintf("0x%02x", data[0])
+	case 2:
+		return fmt.Sprintf("0x%04x", binary.LittleEndian.Uint16(data))
+	case 4:
+		return fmt.Sprintf("0x%08x", binary.LittleEndian.Uint32(data))
+	}
+	if len(data) > 16 {
+		return fmt.Sprintf("%+q...", data[:16])
+	}
+	return fmt.Sprintf("%+q", data)
+}
+
+// unalignedIcoFile returns an .ico file with images of sizes not divisible
+// by 8, at odd offsets, listed in a different order than they are stored.
+func unalignedIcoFile() []byte {
+	sizes := []uint32{13, 8, 21}
+	images := []int{2, 0, 1} // order of images in the file
+	offsets := make([]uint32, len(sizes))
+	offset := uint32(binary.Size(ico.ICONDIR{}) + len(sizes)*binary.Size(ico.ICONDIRENTRY{}) + 1)
+	for _, i := range images {
+		offsets[i] = offset
+		offset += sizes[i]
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, ico.ICONDIR{Type: 1, Count: uint16(len(sizes))})
+	for i, size := range sizes {
+		binary.Write(buf, binary.LittleEndian, ico.ICONDIRENTRY{
+			IconDirEntryCommon: ico.IconDirEntryCommon{Width: byte(16 * (i + 1)), Height: byte(16 * (i + 1)), Planes: 1, BitCount: 32, BytesInRes: size},
+			ImageOffset:        offsets[i],
+		})
+	}
+	buf.WriteByte(0xff) // padding
+	for _, i := range images {
+		buf.Write(bytes.Repeat([]byte{byte('a' + i)}, int(sizes[i])))
+	}
+	return buf.Bytes()
+}
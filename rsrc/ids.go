@@ -0,0 +1,50 @@
+package rsrc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+)
+
+// WriteCHeader writes a resource.h file, defining the IDs of resources that
+// have a Symbol, for use from C code (e.g. via cgo). The definitions are
+// guarded by the RSRC_RESOURCE_H macro, so the file can be included twice.
+func WriteCHeader(w io.Writer, resources []Resource) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// Code generated by rsrc; DO NOT EDIT.\n\n")
+	fmt.Fprintf(bw, "#ifndef RSRC_RESOURCE_H\n#define RSRC_RESOURCE_H\n\n")
+	for _, r := range resources {
+		if r.Symbol == "" {
+			continue
+		}
+		fmt.Fprintf(bw, "#define %-31s %-5d // %s, from %q\n", r.Symbol, r.ID, typeNames[r.Type], r.File)
+	}
+	fmt.Fprintf(bw, "\n#endif // RSRC_RESOURCE_H\n")
+	return bw.Flush()
+}
+
+// WriteGoIDs writes Go source code of package pkg, declaring constants with
+// IDs of resources that have a Symbol. Unlike WriteGoAccessors, the code does
+// not depend on Windows APIs, so it can be saved in a file without
+// platform-specific suffix.
+func WriteGoIDs(w io.Writer, pkg string, resources []Resource) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rsrc; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	fmt.Fprintf(&buf, "// IDs of embedded resources.\nconst (\n")
+	for _, r := range resources {
+		if r.Symbol == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "\t%s = %d // %s, from %q\n", r.Symbol, r.ID, typeNames[r.Type], r.File)
+	}
+	fmt.Fprintf(&buf, ")\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("rsrc: error formatting Go code: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
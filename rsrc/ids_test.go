@@ -0,0 +1,58 @@
+package rsrc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+var testResources = []Resource{
+	{Type: coff.RT_MANIFEST, ID: 1, Symbol: "IDR_MANIFEST", File: "app.manifest"},
+	{Type: coff.RT_ICON, ID: 2, File: "icons/app.ico"},
+	{Type: coff.RT_GROUP_ICON, ID: 101, Symbol: "IDI_APP", File: "icons/app.ico"},
+	{Type: coff.RT_HTML, Name: "INDEX.HTML", File: "web/index.html"},
+	{Type: coff.RT_BITMAP, ID: 65535, Symbol: "IDB_SPLASH_SCREEN_LARGE", File: `img\splash "large".png`},
+}
+
+func TestWriteCHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteCHeader(buf, testResources); err != nil {
+		t.Fatal(err)
+	}
+	want := `// Code generated by rsrc; DO NOT EDIT.
+
+#ifndef RSRC_RESOURCE_H
+#define RSRC_RESOURCE_H
+
+#define IDR_MANIFEST                    1     // RT_MANIFEST, from "app.manifest"
+#define IDI_APP                         101   // RT_GROUP_ICON, from "icons/app.ico"
+#define IDB_SPLASH_SCREEN_LARGE         65535 // RT_BITMAP, from "img\\splash \"large\".png"
+
+#endif // RSRC_RESOURCE_H
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteGoIDs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteGoIDs(buf, "app", testResources); err != nil {
+		t.Fatal(err)
+	}
+	want := `// Code generated by rsrc; DO NOT EDIT.
+
+package app
+
+// IDs of embedded resources.
+const (
+	IDR_MANIFEST            = 1     // RT_MANIFEST, from "app.manifest"
+	IDI_APP                 = 101   // RT_GROUP_ICON, from "icons/app.ico"
+	IDB_SPLASH_SCREEN_LARGE = 65535 // RT_BITMAP, from "img\\splash \"large\".png"
+)
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package rsrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"unicode/utf16"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+// resMagic is the empty entry which starts every 32-bit .res file.
+var resMagic = []byte{0, 0, 0, 0, 0x20, 0, 0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff, 0, 0}
+
+// ReadResourceFile reads resources from a .res file, from a COFF object file
+// (e.g. .syso), or from a PE image (.exe, .dll). Data of the returned
+// resources is a *bytes.Reader.
+func ReadResourceFile(fname string) ([]coff.Resource, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var resources []coff.Resource
+	if bytes.HasPrefix(data, resMagic) {
+		resources, err = ParseRES(data)
+	} else {
+		resources, err = coff.ReadRSRC(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rsrc: error reading resources from '%s': %s", fname, err)
+	}
+	return resources, nil
+}
+
+// ParseRES decodes resources stored in a 32-bit .res file, as written by
+// rc.exe and windres. Data of the returned resources is a *bytes.Reader, in
+// the order of the file.
+func ParseRES(data []byte) ([]coff.Resource, error) {
+	var resources []coff.Resource
+	for off := 0; off < len(data); {
+		if len(data)-off < 8 {
+			return nil, fmt.Errorf("rsrc: .res entry header truncated at offset 0x%x", off)
+		}
+		dataSize := binary.LittleEndian.Uint32(data[off:])
+		headerSize := binary.LittleEndian.Uint32(data[off+4:])
+		if headerSize < 8 || uint64(headerSize)+uint64(dataSize) > uint64(len(data)-off) {
+			return nil, fmt.Errorf("rsrc: bad size of .res entry at offset 0x%x", off)
+		}
+		header := data[off : off+int(headerSize)]
+		var r coff.Resource
+		var id uint32
+		var i int
+		var err error
+		r.Type, r.TypeName, i, err = resNameOrID(header, 8)
+		if err != nil {
+			return nil, fmt.Errorf("rsrc: bad type of .res entry at offset 0x%x: %s", off, err)
+		}
+		id, r.Name, i, err = resNameOrID(header, i)
+		if err != nil {
+			return nil, fmt.Errorf("rsrc: bad name of .res entry at offset 0x%x: %s", off, err)
+		}
+		r.ID = uint16(id)
+		i = (i + 3) &^ 3
+		// DataVersion uint32, MemoryFlags uint16, LanguageId uint16, ...
+		if i+8 > len(header) {
+			return nil, fmt.Errorf("rsrc: .res entry header truncated at offset 0x%x", off)
+		}
+		r.Lang = binary.LittleEndian.Uint16(header[i+6:])
+		start := off + int(headerSize)
+		r.Data = bytes.NewReader(data[start : start+int(dataSize)])
+		off = (start + int(dataSize) + 3) &^ 3
+		if dataSize == 0 && r.Type == 0 && r.TypeName == "" {
+			continue // the empty entry starting the file
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+// resNameOrID decodes a type or name at offset i of a .res entry header: an
+// ordinal written as 0xFFFF followed by the ID, or a NUL-terminated UTF-16
+// string. Returns the offset following it.
+func resNameOrID(header []byte, i int) (id uint32, name string, next int, err error) {
+	if i+4 > len(header) {
+		return 0, "", 0, fmt.Errorf("header truncated")
+	}
+	if binary.LittleEndian.Uint16(header[i:]) == 0xffff {
+		return uint32(binary.LittleEndian.Uint16(header[i+2:])), "", i + 4, nil
+	}
+	var u []uint16
+	for ; ; i += 2 {
+		if i+2 > len(header) {
+			return 0, "", 0, fmt.Errorf("name not terminated")
+		}
+		c := binary.LittleEndian.Uint16(header[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return 0, string(utf16.Decode(u)), i + 2, nil
+}
@@ -0,0 +1,89 @@
+package rsrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/akavel/rsrc/internal/testutil"
+)
+
+// resEntry encodes an entry of a .res file; kind and name are either an
+// uint16 ID or a string.
+func resEntry(kind, name interface{}, lang uint16, data string) []byte {
+	hdr := &bytes.Buffer{}
+	for _, v := range []interface{}{kind, name} {
+		switch v := v.(type) {
+		case uint16:
+			binary.Write(hdr, binary.LittleEndian, []uint16{0xffff, v})
+		case string:
+			binary.Write(hdr, binary.LittleEndian, utf16.Encode([]rune(v+"\000")))
+		}
+	}
+	hdr.Write(make([]byte, -hdr.Len()&3))
+	binary.Write(hdr, binary.LittleEndian, struct {
+		DataVersion     uint32
+		MemoryFlags     uint16
+		LanguageId      uint16
+		Version         uint32
+		Characteristics uint32
+	}{MemoryFlags: 0x30, LanguageId: lang})
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []uint32{uint32(len(data)), uint32(8 + hdr.Len())})
+	buf.Write(hdr.Bytes())
+	buf.WriteString(data)
+	buf.Write(make([]byte, -buf.Len()&3))
+	return buf.Bytes()
+}
+
+func TestParseRES(t *testing.T) {
+	res := bytes.Join([][]byte{
+		resEntry(uint16(0), uint16(0), 0, ""),
+		resEntry(uint16(24), uint16(1), 0x0409, "<assembly/>"),
+		resEntry("PNG", "LOGO", 0x0415, "png"),
+	}, nil)
+	if !bytes.HasPrefix(res, resMagic) {
+		t.Fatalf("bad test data: % x", res[:16])
+	}
+	resources, err := ParseRES(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range resources {
+		data, _ := ioutil.ReadAll(r.Data.(*bytes.Reader))
+		got = append(got, r.String()+": "+string(data))
+	}
+	want := []string{
+		"type 24, ID 1, language 0x0409: <assembly/>",
+		"type 'PNG', name 'LOGO', language 0x0415: png",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, n := range []int{len(res) - 4, len(res) - 40} {
+		if _, err := ParseRES(res[:n]); err == nil {
+			t.Errorf("expected error for data truncated to %d bytes", n)
+		}
+	}
+}
+
+func FuzzParseRES(f *testing.F) {
+	f.Add(bytes.Join([][]byte{
+		resEntry(uint16(0), uint16(0), 0, ""),
+		resEntry(uint16(24), uint16(1), 0x0409, "<assembly/>"),
+		resEntry("PNG", "LOGO", 0x0415, "png"),
+	}, nil))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resources, err := ParseRES(data)
+		if err != nil {
+			return
+		}
+		testutil.CheckDataSizes(t, resources, len(data))
+	})
+}
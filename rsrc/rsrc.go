@@ -1,16 +1,24 @@
 package rsrc
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"image/png"
 	"io"
-	"os"
+	"io/fs"
+	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/akavel/rsrc/accel"
 	"github.com/akavel/rsrc/binutil"
+	"github.com/akavel/rsrc/bmp"
 	"github.com/akavel/rsrc/coff"
 	"github.com/akavel/rsrc/ico"
 	"github.com/akavel/rsrc/internal"
+	"github.com/akavel/rsrc/msgtable"
+	"github.com/akavel/rsrc/versioninfo"
 )
 
 // on storing icons, see: http://blogs.msdn.com/b/oldnewthing/archive/2012/07/20/10331787.aspx
@@ -28,76 +36,421 @@ type _GRPICONDIRENTRY struct {
 	Id uint16
 }
 
+// Files lists paths of input files to embed. Empty fields are skipped.
+//
+// Paths of files embedded as resources with numeric IDs (i.e. Manifest,
+// Icons, Bitmaps, Accelerators and MessageTable) may be written as
+// [SYMBOL=]PATH[@ID], e.g. "IDI_APP=icons/app.ico@101", to pin the ID of the
+// resource and the symbolic name used for it in generated code. IDs are
+// pinned separately for each resource type. Resources without an explicit ID
+// get the lowest unused ID from AutoIDs, except for the manifest, which gets
+// ID 1, the only one loaded by Windows; ID 1 is then not assigned
+// automatically to other resources.
+type Files struct {
+	Manifest     string
+	Icons        []string
+	Bitmaps      []string // .bmp or .png files
+	Accelerators string   // text file in format accepted by accel.Parse
+	MessageTable string   // message text (.mc) file; its ID must be 1, if specified
+	HTMLDir      string   // directory with files to embed as RT_HTML, for the res:// protocol, by base names
+
+	DataDir     string   // directory with files to embed as RT_RCDATA, plus an index
+	DataInclude []string // glob patterns of files in DataDir to embed; all if empty
+	DataExclude []string // glob patterns of files in DataDir to skip
+
+	AutoIDs IDRange // IDs for resources without explicit IDs; 1-65535 if zero
+
+	VersionInfo *versioninfo.Info // added as RT_VERSION resource, if set
+}
+
+// IDRange is an inclusive range of resource IDs.
+type IDRange struct {
+	First, Last uint16
+}
+
+// ParseIDRange parses an ID range written as "FIRST-LAST", e.g. "100-199".
+func ParseIDRange(s string) (IDRange, error) {
+	i := strings.IndexByte(s, '-')
+	if i < 0 {
+		return IDRange{}, fmt.Errorf("rsrc: bad ID range '%s': expected FIRST-LAST", s)
+	}
+	first, err1 := strconv.ParseUint(s[:i], 10, 16)
+	last, err2 := strconv.ParseUint(s[i+1:], 10, 16)
+	if err1 != nil || err2 != nil || first == 0 || first > last {
+		return IDRange{}, fmt.Errorf("rsrc: bad ID range '%s': expected FIRST-LAST, with 1 <= FIRST <= LAST <= 65535", s)
+	}
+	return IDRange{First: uint16(first), Last: uint16(last)}, nil
+}
+
+// Resource describes a resource embedded by EmbedFiles.
+type Resource struct {
+	Type   uint32
+	ID     uint16
+	Name   string // set instead of ID for named resources
+	Symbol string // symbolic name of the ID (e.g. "IDI_APP"), empty for resources not meant to be used directly
+	File   string // path of the input file
+}
+
+// Embed writes a COFF file with the manifest fnamein and the comma-separated
+// list of icons fnameico.
 func Embed(fnameout, arch, fnamein, fnameico string) error {
-	lastid := uint16(0)
-	newid := func() uint16 {
-		lastid++
-		return lastid
+	files := Files{Manifest: fnamein}
+	if fnameico != "" {
+		files.Icons = strings.Split(fnameico, ",")
 	}
+	_, err := EmbedFiles(fnameout, arch, files)
+	return err
+}
 
-	out := coff.NewRSRC()
-	err := out.Arch(arch)
+// EmbedFiles writes a COFF file with all the resources listed in files, and
+// returns descriptions of the embedded resources, in order of addition. If
+// fnameout is "-", the file is written to standard output. An existing file
+// is replaced atomically, and left untouched if its contents would not change.
+// Warnings (see ResourceSet.Warnings) are not reported.
+func EmbedFiles(fnameout, arch string, files Files) ([]Resource, error) {
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetIDRange(files.AutoIDs)
+	err := set.AddFiles(files)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	out, err := set.build(arch)
+	if err != nil {
+		return nil, err
+	}
+	err = internal.Write(out, fnameout)
+	if err != nil {
+		return nil, err
+	}
+	return set.Resources(), nil
+}
 
-	if fnamein != "" {
-		manifest, err := binutil.SizedOpen(fnamein)
-		if err != nil {
-			return fmt.Errorf("rsrc: error opening manifest file '%s': %s", fnamein, err)
+// embedder keeps track of resources added to a Coff, and of files which must
+// stay open until it is written.
+type embedder struct {
+	out       *coff.Coff
+	ids       IDRange
+	next      int                          // next candidate for automatically assigned ID
+	pinned    map[uint32]map[uint16]bool   // explicitly specified IDs, by resource type
+	reserved  map[uint16]bool              // IDs not assigned automatically to any resource
+	used      map[uint32]map[uint16]string // input files by resource type and ID
+	lang      uint16                       // language of added resources
+	fsys      fs.FS                        // if nil, the OS filesystem is used
+	version   *versioninfo.Info            // encoded again when written, see ResourceSet.build
+	resources []Resource
+	symbols   map[string]bool
+	closers   []io.Closer
+	warnings  []string       // see ResourceSet.Warnings
+	messages  *msgtable.File // see ResourceSet.MessageTable
+}
+
+// newid returns the lowest ID from the automatic range that is neither
+// pinned for nor used by resources of kind, nor reserved, and was not
+// assigned yet.
+func (e *embedder) newid(kind uint32) (uint16, error) {
+	for ; e.next <= int(e.ids.Last); e.next++ {
+		id := uint16(e.next)
+		_, used := e.used[kind][id]
+		if !e.pinned[kind][id] && !e.reserved[id] && !used {
+			e.next++
+			return id, nil
 		}
-		defer manifest.Close()
+	}
+	return 0, fmt.Errorf("rsrc: no more free IDs in range %d-%d", e.ids.First, e.ids.Last)
+}
 
-		id := newid()
-		out.AddResource(coff.RT_MANIFEST, id, manifest)
-		// TODO(akavel): reintroduce the Printlns in package main after Embed returns
-		// fmt.Println("Manifest ID: ", id)
+// pin marks the explicit ID of spec as used by a resource of kind, so that it
+// is not assigned automatically to other resources of that kind. A manifest
+// without an explicit ID gets ID 1, as Windows loads no other, and ID 1 is
+// then not assigned automatically to any resources.
+func (e *embedder) pin(kind uint32, spec *fileSpec) {
+	if kind == coff.RT_MANIFEST && spec.id == 0 {
+		spec.id = 1
+		e.reserved[spec.id] = true
 	}
-	if fnameico != "" {
-		for _, fnameicosingle := range strings.Split(fnameico, ",") {
-			f, err := addIcon(out, fnameicosingle, newid)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
+	if spec.id == 0 {
+		return
+	}
+	if e.pinned[kind] == nil {
+		e.pinned[kind] = map[uint16]bool{}
+	}
+	e.pinned[kind][spec.id] = true
+}
+
+// id returns the explicit ID of spec, or a newly assigned one.
+func (e *embedder) id(kind uint32, spec fileSpec) (uint16, error) {
+	e.pin(kind, &spec)
+	if spec.id != 0 {
+		return spec.id, nil
+	}
+	return e.newid(kind)
+}
+
+// open opens input file fname, from e.fsys if set.
+func (e *embedder) open(fname string) (*binutil.SizedFile, error) {
+	if e.fsys != nil {
+		return binutil.SizedOpenFS(e.fsys, fname)
+	}
+	return binutil.SizedOpen(fname)
+}
+
+func (e *embedder) close() {
+	for _, c := range e.closers {
+		c.Close()
+	}
+}
+
+// add adds a resource, verifying that its ID was not already used by another
+// resource of the same kind.
+func (e *embedder) add(kind uint32, id uint16, symbol, fname string, data coff.Sizer) error {
+	err := e.claim(kind, id, fname)
+	if err != nil {
+		return err
+	}
+	return e.addClaimed(kind, id, symbol, fname, data)
+}
+
+// addClaimed is like add, for an ID already claimed.
+func (e *embedder) addClaimed(kind uint32, id uint16, symbol, fname string, data coff.Sizer) error {
+	err := e.out.AddResourceLang(kind, id, e.lang, data)
+	if err != nil {
+		return err
+	}
+	e.resources = append(e.resources, Resource{
+		Type:   kind,
+		ID:     id,
+		Symbol: symbol,
+		File:   fname,
+	})
+	return nil
+}
+
+// claim marks an ID of a resource kind as used by file fname.
+func (e *embedder) claim(kind uint32, id uint16, fname string) error {
+	if e.used[kind] == nil {
+		e.used[kind] = map[uint16]string{}
+	}
+	if prev, ok := e.used[kind][id]; ok {
+		return fmt.Errorf("rsrc: ID %d of %s for '%s' is already used by '%s'", id, typeNames[kind], fname, prev)
+	}
+	e.used[kind][id] = fname
+	return nil
+}
+
+// addFile adds a resource with ID and symbol as specified in spec, or
+// assigned automatically.
+func (e *embedder) addFile(kind uint32, spec fileSpec, data coff.Sizer) error {
+	id, err := e.id(kind, spec)
+	if err != nil {
+		return err
+	}
+	symbol, err := e.symbol(kind, spec)
+	if err != nil {
+		return err
+	}
+	return e.add(kind, id, symbol, spec.path, data)
+}
+
+func (e *embedder) addNamed(kind uint32, name string, fname string, data coff.Sizer) error {
+	err := e.out.AddNamedResourceLang(kind, name, e.lang, data)
+	if err != nil {
+		return err
+	}
+	e.resources = append(e.resources, Resource{Type: kind, Name: name, File: fname})
+	return nil
+}
+
+// symbol returns the symbolic name from spec, or builds a unique one from the
+// base name of the file, prefixed in the style of resource.h files generated
+// by Visual Studio, e.g. "icons/app.ico" gives "IDI_APP".
+func (e *embedder) symbol(kind uint32, spec fileSpec) (string, error) {
+	if spec.symbol != "" {
+		if e.symbols[spec.symbol] {
+			return "", fmt.Errorf("rsrc: symbol %s for '%s' is already used", spec.symbol, spec.path)
 		}
+		e.symbols[spec.symbol] = true
+		return spec.symbol, nil
 	}
 
-	out.Freeze()
+	prefix := "IDR_"
+	switch kind {
+	case coff.RT_GROUP_ICON:
+		prefix = "IDI_"
+	case coff.RT_BITMAP:
+		prefix = "IDB_"
+	}
+	base := filepath.Base(spec.path)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	sym := prefix + strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, base)
 
-	return internal.Write(out, fnameout)
+	unique := sym
+	for i := 2; e.symbols[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", sym, i)
+	}
+	e.symbols[unique] = true
+	return unique, nil
 }
 
-func addIcon(out *coff.Coff, fname string, newid func() uint16) (io.Closer, error) {
-	f, err := os.Open(fname)
+func (e *embedder) addIcon(spec fileSpec) error {
+	f, err := e.open(spec.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	e.closers = append(e.closers, f)
 
 	icons, err := ico.DecodeHeaders(f)
 	if err != nil {
-		f.Close()
-		return nil, err
+		return fmt.Errorf("rsrc: error decoding icon file '%s': %s", spec.path, err)
+	}
+
+	entries := make([]ico.IconDirEntryCommon, 0, len(icons))
+	images := make([]coff.Sizer, 0, len(icons))
+	for i, icon := range icons {
+		// don't trust the headers: an image outside of the file would
+		// only fail when writing, or be silently truncated
+		if int64(icon.ImageOffset)+int64(icon.BytesInRes) > f.Size() {
+			return fmt.Errorf("rsrc: image %d of icon file '%s', at offset %d, of %d bytes, exceeds the file size of %d bytes", i, spec.path, icon.ImageOffset, icon.BytesInRes, f.Size())
+		}
+		entries = append(entries, icon.IconDirEntryCommon)
+		images = append(images, io.NewSectionReader(f, int64(icon.ImageOffset), int64(icon.BytesInRes)))
+	}
+	return e.addIconGroup(spec, entries, images)
+}
+
+// addIconGroup adds a RT_ICON resource for each of images, described by the
+// corresponding entry, and a RT_GROUP_ICON listing them. The group and all the
+// IDs are checked first, so that no images are added if any of them fails.
+func (e *embedder) addIconGroup(spec fileSpec, entries []ico.IconDirEntryCommon, images []coff.Sizer) error {
+	if len(images) == 0 {
+		return nil
+	}
+	group := _GRPICONDIR{ICONDIR: ico.ICONDIR{
+		Reserved: 0, // magic num.
+		Type:     1, // magic num.
+		Count:    uint16(len(images)),
+	}}
+	gid, err := e.id(coff.RT_GROUP_ICON, spec)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		id, err := e.newid(coff.RT_ICON)
+		if err != nil {
+			return err
+		}
+		group.Entries = append(group.Entries, _GRPICONDIRENTRY{entries[i], id})
+	}
+	err = e.claim(coff.RT_GROUP_ICON, gid, spec.path)
+	if err != nil {
+		return err
+	}
+	symbol, err := e.symbol(coff.RT_GROUP_ICON, spec)
+	if err != nil {
+		delete(e.used[coff.RT_GROUP_ICON], gid)
+		return err
+	}
+	for i, image := range images {
+		err = e.add(coff.RT_ICON, group.Entries[i].Id, "", spec.path, image)
+		if err != nil {
+			return err
+		}
+	}
+	return e.addClaimed(coff.RT_GROUP_ICON, gid, symbol, spec.path, group)
+}
+
+// addBitmap adds a RT_BITMAP resource from a .bmp file, or from a .png file
+// converted to a DIB.
+func (e *embedder) addBitmap(spec fileSpec) error {
+	fname := spec.path
+	f, err := e.open(fname)
+	if err != nil {
+		return err
 	}
+	e.closers = append(e.closers, f)
 
-	if len(icons) > 0 {
-		// RT_ICONs
-		group := _GRPICONDIR{ICONDIR: ico.ICONDIR{
-			Reserved: 0, // magic num.
-			Type:     1, // magic num.
-			Count:    uint16(len(icons)),
-		}}
-		gid := newid()
-		for _, icon := range icons {
-			id := newid()
-			r := io.NewSectionReader(f, int64(icon.ImageOffset), int64(icon.BytesInRes))
-			out.AddResource(coff.RT_ICON, id, r)
-			group.Entries = append(group.Entries, _GRPICONDIRENTRY{icon.IconDirEntryCommon, id})
+	if strings.EqualFold(filepath.Ext(fname), ".png") {
+		img, err := png.Decode(f)
+		if err != nil {
+			return fmt.Errorf("rsrc: error decoding PNG file '%s': %s", fname, err)
 		}
-		out.AddResource(coff.RT_GROUP_ICON, gid, group)
-		// TODO(akavel): reintroduce the Printlns in package main after Embed returns
-		// fmt.Println("Icon ", fname, " ID: ", id)
+		return e.addFile(coff.RT_BITMAP, spec, bytes.NewReader(bmp.FromImage(img)))
 	}
 
-	return f, nil
+	hdr, err := bmp.DecodeFileHeader(f)
+	if err != nil {
+		return fmt.Errorf("rsrc: error decoding BMP file '%s': %s", fname, err)
+	}
+	// RT_BITMAP is a DIB, i.e. a .bmp file without BITMAPFILEHEADER
+	n := int64(binary.Size(hdr))
+	return e.addFile(coff.RT_BITMAP, spec, io.NewSectionReader(f, n, f.Size()-n))
+}
+
+func (e *embedder) addAccelerators(spec fileSpec) error {
+	fname := spec.path
+	f, err := e.open(fname)
+	if err != nil {
+		return fmt.Errorf("rsrc: error opening accelerators file '%s': %s", fname, err)
+	}
+	defer f.Close()
+
+	table, err := accel.Parse(f)
+	if err != nil {
+		return fmt.Errorf("rsrc: error parsing accelerators file '%s': %s", fname, err)
+	}
+	return e.addFile(coff.RT_ACCELERATOR, spec, table)
+}
+
+// addMessageTable adds a RT_MESSAGETABLE resource in each of the languages used
+// in the .mc file. The resource always gets ID 1, as this is where
+// FormatMessage looks for it.
+func (e *embedder) addMessageTable(spec fileSpec) error {
+	fname := spec.path
+	if spec.id != 0 && spec.id != 1 {
+		return fmt.Errorf("rsrc: ID of message table '%s' must be 1, got %d", fname, spec.id)
+	}
+	f, err := e.open(fname)
+	if err != nil {
+		return fmt.Errorf("rsrc: error opening message text file '%s': %s", fname, err)
+	}
+	defer f.Close()
+
+	mc, err := msgtable.Parse(f)
+	if err != nil {
+		return fmt.Errorf("rsrc: error parsing message text file '%s': %s", fname, err)
+	}
+	symbol, err := e.symbol(coff.RT_MESSAGETABLE, spec)
+	if err != nil {
+		return err
+	}
+	err = e.claim(coff.RT_MESSAGETABLE, 1, fname)
+	if err != nil {
+		return err
+	}
+	for _, lang := range mc.Languages {
+		data, err := mc.Encode(lang.ID)
+		if err != nil {
+			return fmt.Errorf("rsrc: error in message text file '%s': %s", fname, err)
+		}
+		err = e.out.AddResourceLang(coff.RT_MESSAGETABLE, 1, lang.ID, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+	}
+	e.resources = append(e.resources, Resource{
+		Type:   coff.RT_MESSAGETABLE,
+		ID:     1,
+		Symbol: symbol,
+		File:   fname,
+	})
+	e.messages = mc
+	return nil
 }
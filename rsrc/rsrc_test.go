@@ -0,0 +1,147 @@
+package rsrc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/akavel/rsrc/coff"
+)
+
+func TestParseIDRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want IDRange
+	}{
+		{"100-199", IDRange{100, 199}},
+		{"1-65535", IDRange{1, 65535}},
+		{"7-7", IDRange{7, 7}},
+		{"", IDRange{}},
+		{"100", IDRange{}},
+		{"0-10", IDRange{}},
+		{"20-10", IDRange{}},
+		{"1-65536", IDRange{}},
+		{"-10", IDRange{}},
+		{"a-b", IDRange{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseIDRange(tt.in)
+		if tt.want == (IDRange{}) {
+			if err == nil {
+				t.Errorf("ParseIDRange(%q) = %+v, expected error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseIDRange(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAssignIDs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.manifest": {Data: []byte("<assembly/>")},
+		"app.ico":      {Data: icoFile(t)},
+		"other.ico":    {Data: icoFile(t)},
+		"a.png":        {Data: pngFile(t, 2)},
+		"b.png":        {Data: pngFile(t, 2)},
+	}
+	tests := []struct {
+		name  string
+		files Files
+		want  string
+	}{{
+		name:  "automatic",
+		files: Files{Manifest: "app.manifest", Icons: []string{"app.ico"}, Bitmaps: []string{"a.png"}},
+		want:  "RT_MANIFEST 1, RT_ICON 3, RT_GROUP_ICON 2, RT_BITMAP 4",
+	}, {
+		// the manifest is not moved by other resources pinned at 1
+		name:  "manifest and pinned icon",
+		files: Files{Manifest: "app.manifest", Icons: []string{"app.ico@1"}},
+		want:  "RT_MANIFEST 1, RT_ICON 2, RT_GROUP_ICON 1",
+	}, {
+		// the manifest keeps ID 1 outside of the automatic range
+		name:  "manifest and range",
+		files: Files{Manifest: "app.manifest", Icons: []string{"app.ico"}, AutoIDs: IDRange{100, 199}},
+		want:  "RT_MANIFEST 1, RT_ICON 101, RT_GROUP_ICON 100",
+	}, {
+		name:  "pinned manifest",
+		files: Files{Manifest: "app.manifest@2", Icons: []string{"app.ico"}},
+		want:  "RT_MANIFEST 2, RT_ICON 2, RT_GROUP_ICON 1",
+	}, {
+		// IDs are pinned by type: a bitmap at 1 does not push icons aside
+		name:  "pinned bitmap",
+		files: Files{Icons: []string{"app.ico"}, Bitmaps: []string{"a.png", "b.png@1"}},
+		want:  "RT_ICON 2, RT_GROUP_ICON 1, RT_BITMAP 3, RT_BITMAP 1",
+	}, {
+		// pinned IDs are avoided regardless of order of files
+		name:  "pinned later",
+		files: Files{Icons: []string{"app.ico", "other.ico@1"}},
+		want:  "RT_ICON 3, RT_GROUP_ICON 2, RT_ICON 4, RT_GROUP_ICON 1",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := NewResourceSet()
+			defer set.Close()
+			set.SetFS(fsys)
+			set.SetIDRange(tt.files.AutoIDs)
+			if err := set.AddFiles(tt.files); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range set.Resources() {
+				got = append(got, fmt.Sprintf("%s %d", typeNames[r.Type], r.ID))
+			}
+			if s := strings.Join(got, ", "); s != tt.want {
+				t.Errorf("got IDs: %s\nwant: %s", s, tt.want)
+			}
+		})
+	}
+
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetFS(fsys)
+	err := set.AddFiles(Files{Icons: []string{"app.ico@5", "other.ico@5"}})
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("expected error about ID already used, got: %v", err)
+	}
+	set.SetIDRange(IDRange{10, 10})
+	err = set.AddIconFile("app.ico")
+	if err == nil || !strings.Contains(err.Error(), "no more free IDs") {
+		t.Errorf("expected error about no more free IDs, got: %v", err)
+	}
+	set.SetIDRange(IDRange{})
+	err = set.AddIconFile("IDI_MAIN=app.ico@6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.AddIconFile("IDI_MAIN=other.ico")
+	if err == nil || !strings.Contains(err.Error(), "symbol IDI_MAIN") {
+		t.Errorf("expected error about symbol already used, got: %v", err)
+	}
+	// failed icons leave no images behind
+	var got []string
+	for _, r := range set.Resources() {
+		got = append(got, fmt.Sprintf("%s %d %s", typeNames[r.Type], r.ID, r.File))
+	}
+	want := "RT_ICON 1 app.ico, RT_GROUP_ICON 5 app.ico, RT_ICON 2 app.ico, RT_GROUP_ICON 6 app.ico"
+	if s := strings.Join(got, ", "); s != want {
+		t.Errorf("got resources: %s\nwant: %s", s, want)
+	}
+
+	// IDs used before changing the range are skipped
+	set = NewResourceSet()
+	defer set.Close()
+	for i, spec := range []string{"a", "b", "c"} {
+		if i == 2 {
+			set.SetIDRange(IDRange{1, 10})
+		}
+		if err := set.AddData(coff.RT_RCDATA, spec, []byte(spec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := set.Resources(); r[2].ID != 3 {
+		t.Errorf("got ID %d after changing range, want 3", r[2].ID)
+	}
+}
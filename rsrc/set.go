@@ -0,0 +1,397 @@
+package rsrc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/ico"
+	"github.com/akavel/rsrc/msgtable"
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+// ResourceSet collects resources to be written to a COFF file, from input
+// files as well as from data in memory. Resources may be added in any order.
+//
+// Methods adding a single resource take a spec naming the resource, written
+// as [SYMBOL=]NAME[@ID] like the paths in Files, e.g. "IDR_CONFIG=config@200".
+// For resources from memory, NAME is only used to build the symbol and in
+// error messages. Resources without an explicit ID get the lowest unused ID
+// from the range set with SetIDRange, except for manifests, which get ID 1.
+//
+// Some input files are kept open until the set is written, so Close must be
+// called when the set is no longer needed.
+//
+// Output is reproducible: resources are stored sorted by type, name or ID,
+// and language, whatever the order in which they were added, and all time
+// stamps are taken from SetTimestamp, or else from the SOURCE_DATE_EPOCH
+// environment variable, or else are zero. Note that automatically assigned
+// IDs, including IDs of images of icons, do depend on the order of adding
+// resources, and of files in lists of Files.
+type ResourceSet struct {
+	e         *embedder
+	timestamp *time.Time
+}
+
+// NewResourceSet returns an empty set, with automatic IDs assigned from the
+// range 1-65535, and resources added in the U.S. English language.
+func NewResourceSet() *ResourceSet {
+	e := &embedder{
+		out:      coff.NewRSRC(),
+		pinned:   map[uint32]map[uint16]bool{},
+		reserved: map[uint16]bool{},
+		used:     map[uint32]map[uint16]string{},
+		symbols:  map[string]bool{},
+		lang:     uint16(coff.LANG_ENTRY.NameOrId),
+	}
+	s := &ResourceSet{e: e}
+	s.SetIDRange(IDRange{})
+	return s
+}
+
+// SetIDRange sets the range of IDs for resources added later without an
+// explicit ID. If ids is zero, the full range 1-65535 is used.
+func (s *ResourceSet) SetIDRange(ids IDRange) {
+	if ids == (IDRange{}) {
+		ids = IDRange{First: 1, Last: 0xffff}
+	}
+	s.e.ids = ids
+	s.e.next = int(ids.First)
+}
+
+// SetFS makes paths of input files added later be resolved in fsys (e.g.
+// an embed.FS), instead of the OS filesystem. Paths must then be valid as
+// described in fs.ValidPath, i.e. use '/' separators and be relative.
+func (s *ResourceSet) SetFS(fsys fs.FS) {
+	s.e.fsys = fsys
+}
+
+// SetLanguage sets the language ID (e.g. 0x0409 for U.S. English) of
+// resources added later. Message tables always use languages declared in
+// their .mc files.
+func (s *ResourceSet) SetLanguage(lang uint16) {
+	s.e.lang = lang
+}
+
+// SetTimestamp sets the time stored in the COFF header, in directories of
+// resources, and as the file date in version information (unless set in the
+// version information itself). Overrides SOURCE_DATE_EPOCH.
+func (s *ResourceSet) SetTimestamp(t time.Time) {
+	s.timestamp = &t
+}
+
+// SourceDateEpoch returns the time set in the SOURCE_DATE_EPOCH environment
+// variable, as seconds since the Unix epoch (see
+// https://reproducible-builds.org/specs/source-date-epoch/), or the zero time
+// if it is not set.
+func SourceDateEpoch() (time.Time, error) {
+	s := os.Getenv("SOURCE_DATE_EPOCH")
+	if s == "" {
+		return time.Time{}, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("rsrc: bad SOURCE_DATE_EPOCH '%s': expected a number of seconds since 1970-01-01", s)
+	}
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// Resources returns descriptions of the resources added so far, in order of
+// addition.
+func (s *ResourceSet) Resources() []Resource {
+	return s.e.resources
+}
+
+// Warnings returns descriptions of problems found so far in added resources,
+// which did not prevent adding them, e.g. of inconsistent version info.
+func (s *ResourceSet) Warnings() []string {
+	return s.e.warnings
+}
+
+// MessageTable returns the message table parsed from the .mc file of
+// Files.MessageTable, e.g. for msgtable.WriteGo, or nil if none was added.
+func (s *ResourceSet) MessageTable() *msgtable.File {
+	return s.e.messages
+}
+
+// AddFiles adds all the resources listed in files. IDs pinned in any of the
+// paths are not assigned automatically to other files of the same type.
+func (s *ResourceSet) AddFiles(files Files) error {
+	e := s.e
+	// parse all paths first, so that automatically assigned IDs can avoid
+	// the pinned ones regardless of order
+	var manifest, accels, mc []fileSpec
+	var err error
+	for _, f := range []struct {
+		specs *[]fileSpec
+		path  string
+	}{{&manifest, files.Manifest}, {&accels, files.Accelerators}, {&mc, files.MessageTable}} {
+		if f.path != "" {
+			*f.specs, err = parseFileSpecs([]string{f.path})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	icons, err := parseFileSpecs(files.Icons)
+	if err != nil {
+		return err
+	}
+	bitmaps, err := parseFileSpecs(files.Bitmaps)
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		kind  uint32
+		specs []fileSpec
+	}{{coff.RT_MANIFEST, manifest}, {coff.RT_GROUP_ICON, icons}, {coff.RT_BITMAP, bitmaps}, {coff.RT_ACCELERATOR, accels}} {
+		for i := range f.specs {
+			e.pin(f.kind, &f.specs[i])
+		}
+	}
+
+	for _, spec := range manifest {
+		f, err := e.open(spec.path)
+		if err != nil {
+			return fmt.Errorf("rsrc: error opening manifest file '%s': %s", spec.path, err)
+		}
+		e.closers = append(e.closers, f)
+		err = e.addFile(coff.RT_MANIFEST, spec, f)
+		if err != nil {
+			return err
+		}
+	}
+	for _, spec := range icons {
+		err := e.addIcon(spec)
+		if err != nil {
+			return err
+		}
+	}
+	for _, spec := range bitmaps {
+		err := e.addBitmap(spec)
+		if err != nil {
+			return err
+		}
+	}
+	for _, spec := range accels {
+		err := e.addAccelerators(spec)
+		if err != nil {
+			return err
+		}
+	}
+	for _, spec := range mc {
+		err := e.addMessageTable(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	if files.HTMLDir != "" {
+		err := e.addHTMLDir(files.HTMLDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if files.DataDir != "" {
+		err := e.addDataDir(files.DataDir, files.DataInclude, files.DataExclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	if files.VersionInfo != nil {
+		err := s.AddVersionInfo(files.VersionInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddManifest adds a RT_MANIFEST resource with contents of a manifest file.
+// Unless spec has an explicit ID, the manifest gets ID 1, as Windows only
+// reads the manifest of an executable with ID 1.
+func (s *ResourceSet) AddManifest(spec string, manifest []byte) error {
+	return s.AddData(coff.RT_MANIFEST, spec, manifest)
+}
+
+// AddIconFile adds the icon read from an .ico file, with path given in spec.
+func (s *ResourceSet) AddIconFile(spec string) error {
+	fspec, err := parseFileSpec(spec)
+	if err != nil {
+		return err
+	}
+	return s.e.addIcon(fspec)
+}
+
+// AddIconImages adds an icon built from images of various sizes, each stored
+// in PNG format. Images should be square, at most 256x256 pixels.
+func (s *ResourceSet) AddIconImages(spec string, images ...image.Image) error {
+	fspec, err := parseFileSpec(spec)
+	if err != nil {
+		return err
+	}
+	entries := make([]ico.IconDirEntryCommon, 0, len(images))
+	data := make([]coff.Sizer, 0, len(images))
+	for _, img := range images {
+		size := img.Bounds().Size()
+		if size.X > 256 || size.Y > 256 {
+			return fmt.Errorf("rsrc: image of icon '%s' is too large: %dx%d, maximum is 256x256", fspec.path, size.X, size.Y)
+		}
+		buf := &bytes.Buffer{}
+		err := png.Encode(buf, img)
+		if err != nil {
+			return fmt.Errorf("rsrc: error encoding image of icon '%s': %s", fspec.path, err)
+		}
+		entries = append(entries, ico.IconDirEntryCommon{
+			Width:      byte(size.X), // 0 means 256
+			Height:     byte(size.Y),
+			Planes:     1,
+			BitCount:   32,
+			BytesInRes: uint32(buf.Len()),
+		})
+		data = append(data, bytes.NewReader(buf.Bytes()))
+	}
+	return s.e.addIconGroup(fspec, entries, data)
+}
+
+// AddData adds a resource of any type with the specified contents, e.g. of
+// type coff.RT_RCDATA.
+func (s *ResourceSet) AddData(kind uint32, spec string, data []byte) error {
+	fspec, err := parseFileSpec(spec)
+	if err != nil {
+		return err
+	}
+	return s.e.addFile(kind, fspec, bytes.NewReader(data))
+}
+
+// AddVersionInfo adds a RT_VERSION resource with ID 1, in each language of
+// the string tables of info. If info.Lang is zero, the language of the set is
+// used. Inconsistencies found by info.Validate are added to Warnings.
+func (s *ResourceSet) AddVersionInfo(info *versioninfo.Info) error {
+	e := s.e
+	vi := *info
+	if vi.Lang == 0 {
+		vi.Lang = e.lang
+	}
+	e.version = &vi
+	for _, problem := range vi.Validate() {
+		e.warnings = append(e.warnings, "version info: "+problem)
+	}
+	spec := fileSpec{path: "VERSIONINFO", id: 1}
+	symbol, err := e.symbol(coff.RT_VERSION, spec)
+	if err != nil {
+		return err
+	}
+	err = e.claim(coff.RT_VERSION, 1, spec.path)
+	if err != nil {
+		return err
+	}
+	for _, lang := range vi.Languages() {
+		err = e.out.AddResourceLang(coff.RT_VERSION, 1, lang, bytes.NewReader(vi.EncodeLang(lang)))
+		if err != nil {
+			return err
+		}
+	}
+	e.resources = append(e.resources, Resource{
+		Type:   coff.RT_VERSION,
+		ID:     1,
+		Symbol: symbol,
+		File:   spec.path,
+	})
+	return nil
+}
+
+// WriteTo writes a COFF file with all the resources of the set, for
+// architecture arch (one of: 386, amd64, arm, arm64). The set may be written
+// multiple times, e.g. for different architectures, without reading input
+// files again.
+func (s *ResourceSet) WriteTo(w io.Writer, arch string) (int64, error) {
+	out, err := s.build(arch)
+	if err != nil {
+		return 0, err
+	}
+	return out.WriteTo(w)
+}
+
+// Layout lists the fields of the COFF file which WriteTo would write for
+// architecture arch, with their offsets and values; see coff.Coff.Layout.
+func (s *ResourceSet) Layout(arch string) ([]coff.Field, error) {
+	out, err := s.build(arch)
+	if err != nil {
+		return nil, err
+	}
+	return out.Layout(), nil
+}
+
+type readerAtSizer interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// outputTime returns the time stamp of output files, as set with
+// SetTimestamp, or taken from SOURCE_DATE_EPOCH.
+func (s *ResourceSet) outputTime() (time.Time, error) {
+	if s.timestamp != nil {
+		return *s.timestamp, nil
+	}
+	return SourceDateEpoch()
+}
+
+// build returns a frozen Coff for arch, with all the resources of the set.
+func (s *ResourceSet) build(arch string) (*coff.Coff, error) {
+	out := coff.NewRSRC()
+	err := out.Arch(arch)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.outputTime()
+	if err != nil {
+		return nil, err
+	}
+	var stamp uint32
+	if !t.IsZero() {
+		if t.Unix() < 0 || t.Unix() > math.MaxUint32 {
+			return nil, fmt.Errorf("rsrc: time stamp %s out of range of COFF files", t.UTC().Format(time.RFC3339))
+		}
+		stamp = uint32(t.Unix())
+	}
+	err = s.e.out.VisitResources(func(r coff.Resource) error {
+		data := r.Data
+		// readers of data are consumed when writing, so make fresh ones
+		// for every Coff
+		if ra, ok := data.(readerAtSizer); ok {
+			data = io.NewSectionReader(ra, 0, ra.Size())
+		}
+		if r.Type == coff.RT_VERSION && r.ID == 1 && s.e.version != nil && s.e.version.Date.IsZero() {
+			vi := *s.e.version
+			vi.Date = t
+			data = bytes.NewReader(vi.EncodeLang(r.Lang))
+		}
+		if r.Name != "" {
+			return out.AddNamedResourceLang(r.Type, r.Name, r.Lang, data)
+		}
+		return out.AddResourceLang(r.Type, r.ID, r.Lang, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.SetTimeDateStamp(stamp)
+	out.Freeze()
+	return out, nil
+}
+
+// Close closes input files kept open by the set.
+func (s *ResourceSet) Close() error {
+	s.e.close()
+	return nil
+}
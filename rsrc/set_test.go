@@ -0,0 +1,339 @@
+package rsrc
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/akavel/rsrc/coff"
+	"github.com/akavel/rsrc/ico"
+	"github.com/akavel/rsrc/internal/testutil"
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+func TestResourceSetInMemory(t *testing.T) {
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetIDRange(IDRange{First: 100, Last: 199})
+	set.SetLanguage(0x0415)
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(set.AddManifest("app.manifest@1", []byte("<assembly/>")))
+	must(set.AddIconImages("IDI_APP=app", image.NewNRGBA(image.Rect(0, 0, 16, 16)), image.NewNRGBA(image.Rect(0, 0, 256, 256))))
+	must(set.AddData(coff.RT_RCDATA, "config.json", []byte(`{}`)))
+	must(set.AddVersionInfo(&versioninfo.Info{
+		FileVersion: versioninfo.Version{1, 2, 3, 4},
+		Strings:     map[string]string{"ProductName": "Test"},
+		Tables:      []versioninfo.StringTable{{Translation: versioninfo.Translation{Lang: 0x0409}}},
+	}))
+
+	err := set.AddData(coff.RT_RCDATA, "other.json@103", []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("expected error about ID already used, got: %v", err)
+	}
+	err = set.AddIconImages("big", image.NewNRGBA(image.Rect(0, 0, 512, 512)))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected error about too large icon, got: %v", err)
+	}
+
+	var got []string
+	for _, r := range set.Resources() {
+		got = append(got, fmt.Sprintf("%s %d %s", typeNames[r.Type], r.ID, r.Symbol))
+	}
+	want := []string{
+		"RT_MANIFEST 1 IDR_APP",
+		"RT_ICON 101 ",
+		"RT_ICON 102 ",
+		"RT_GROUP_ICON 100 IDI_APP",
+		"RT_RCDATA 103 IDR_CONFIG",
+		"RT_VERSION 1 IDR_VERSIONINFO",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got resources:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// version info is stored in each language of its string tables
+	var langs []string
+	for _, r := range set.e.out.Resources() {
+		if r.Type == coff.RT_VERSION {
+			langs = append(langs, fmt.Sprintf("0x%04X", r.Lang))
+		}
+	}
+	if got := strings.Join(langs, " "); got != "0x0409 0x0415" {
+		t.Errorf("got languages of version info: %s", got)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := set.WriteTo(buf, "arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+	}
+	f, err := pe.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Machine != pe.IMAGE_FILE_MACHINE_ARM64 {
+		t.Errorf("got machine 0x%x, want arm64", f.Machine)
+	}
+	if n := len(f.Section(".rsrc").Relocs); n != len(want)+1 {
+		t.Errorf("got %d relocations, want %d", n, len(want)+1)
+	}
+	if problems := coff.Verify(buf.Bytes()); problems != nil {
+		t.Errorf("problems found in output:\n%s", strings.Join(problems, "\n"))
+	}
+}
+
+// icoFile returns an .ico file with a single 16x16 PNG image.
+func icoFile(t *testing.T) []byte {
+	img := &bytes.Buffer{}
+	if err := png.Encode(img, image.NewNRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, ico.ICONDIR{Type: 1, Count: 1})
+	binary.Write(buf, binary.LittleEndian, ico.ICONDIRENTRY{
+		IconDirEntryCommon: ico.IconDirEntryCommon{Width: 16, Height: 16, Planes: 1, BitCount: 32, BytesInRes: uint32(img.Len())},
+		ImageOffset:        uint32(binary.Size(ico.ICONDIR{}) + binary.Size(ico.ICONDIRENTRY{})),
+	})
+	buf.Write(img.Bytes())
+	return buf.Bytes()
+}
+
+// pngFile returns a PNG file with an empty image of size n x n.
+func pngFile(t *testing.T, n int) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, n, n))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVersionInfoWarnings(t *testing.T) {
+	set := NewResourceSet()
+	defer set.Close()
+	err := set.AddVersionInfo(&versioninfo.Info{
+		Lang:         0x0409,
+		Translations: []versioninfo.Translation{{Lang: 0x0407, CodePage: versioninfo.CP_UNICODE}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"version info: translation 040704b0 has no matching string table",
+		"version info: string table 040904b0 is not listed in translations",
+	}
+	if got := set.Warnings(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestResourceSetFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.manifest":           {Data: []byte("<assembly/>")},
+		"icons/app.ico":          {Data: icoFile(t)},
+		"img/splash.png":         {Data: pngFile(t, 4)},
+		"keys.txt":               {Data: []byte("Ctrl+S 100\n")},
+		"web/index.html":         {Data: []byte("<html/>")},
+		"web/css/style.css":      {Data: []byte("body{}")},
+		"assets/config.json":     {Data: []byte("{}")},
+		"assets/skip/large.bin":  {Data: []byte("x")},
+		"assets/nested/data.txt": {Data: []byte("data")},
+	}
+
+	set := NewResourceSet()
+	defer set.Close()
+	set.SetFS(fsys)
+	err := set.AddFiles(Files{
+		Manifest:     "app.manifest",
+		Icons:        []string{"icons/app.ico"},
+		Bitmaps:      []string{"img/splash.png"},
+		Accelerators: "keys.txt",
+		HTMLDir:      "web",
+		DataDir:      "assets",
+		DataExclude:  []string{"skip/*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := set.AddIconFile("missing.ico"); err == nil {
+		t.Errorf("expected error for file missing in fsys")
+	}
+
+	var got []string
+	for _, r := range set.Resources() {
+		got = append(got, fmt.Sprintf("%s %d%s %s", typeNames[r.Type], r.ID, r.Name, r.File))
+	}
+	want := []string{
+		"RT_MANIFEST 1 app.manifest",
+		"RT_ICON 3 icons/app.ico",
+		"RT_GROUP_ICON 2 icons/app.ico",
+		"RT_BITMAP 4 img/splash.png",
+		"RT_ACCELERATOR 5 keys.txt",
+		"RT_HTML 0STYLE.CSS web/css/style.css",
+		"RT_HTML 0INDEX.HTML web/index.html",
+		"RT_RCDATA 0CONFIG.JSON assets/config.json",
+		"RT_RCDATA 0NESTED/DATA.TXT assets/nested/data.txt",
+		"RT_RCDATA 0" + DataIndexName + " assets",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got resources:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := set.WriteTo(buf, "amd64"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := pe.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.Section(".rsrc").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"<assembly/>", "<html/>", "body{}", "config.json\tCONFIG.JSON\t2\t"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("data %q not found in .rsrc section", s)
+		}
+	}
+
+	// the set can be written again, for another architecture
+	buf.Reset()
+	if _, err := set.WriteTo(buf, "386"); err != nil {
+		t.Fatal(err)
+	}
+	f, err = pe.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data386, err := f.Section(".rsrc").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, data386) {
+		t.Errorf(".rsrc section differs between amd64 and 386")
+	}
+	if problems := coff.Verify(buf.Bytes()); problems != nil {
+		t.Errorf("problems found in output:\n%s", strings.Join(problems, "\n"))
+	}
+}
+
+// reversedFS lists directories in reverse order, unlike fstest.MapFS. It does
+// not embed the MapFS, whose Sub method would bypass ReadDir.
+type reversedFS struct{ fsys fstest.MapFS }
+
+func (fsys reversedFS) Open(name string) (fs.File, error) {
+	return fsys.fsys.Open(name)
+}
+
+func (fsys reversedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fsys.fsys.ReadDir(name)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, err
+}
+
+func TestResourceSetReproducible(t *testing.T) {
+	files := fstest.MapFS{
+		"app.manifest":    {Data: []byte("<assembly/>")},
+		"app.ico":         {Data: icoFile(t)},
+		"img/a.png":       {Data: pngFile(t, 4)},
+		"img/b.png":       {Data: pngFile(t, 8)},
+		"assets/one.txt":  {Data: []byte("1")},
+		"assets/two.txt":  {Data: []byte("2")},
+		"assets/sub/3.md": {Data: []byte("3")},
+	}
+	write := func(fsys fs.FS, bitmaps []string) []byte {
+		t.Helper()
+		set := NewResourceSet()
+		defer set.Close()
+		set.SetFS(fsys)
+		err := set.AddFiles(Files{
+			Manifest:    "app.manifest@1",
+			Icons:       []string{"app.ico"},
+			Bitmaps:     bitmaps,
+			DataDir:     "assets",
+			VersionInfo: &versioninfo.Info{Strings: map[string]string{"ProductName": "Test"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if _, err := set.WriteTo(buf, "amd64"); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	for _, epoch := range []string{"", "1700000000"} {
+		os.Setenv("SOURCE_DATE_EPOCH", epoch)
+		a := write(files, []string{"img/a.png@10", "img/b.png@20"})
+		b := write(reversedFS{files}, []string{"img/b.png@20", "img/a.png@10"})
+		if !bytes.Equal(a, b) {
+			t.Errorf("SOURCE_DATE_EPOCH=%q: output differs for inputs in different order", epoch)
+		}
+		f, err := pe.NewFile(bytes.NewReader(a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := uint32(0)
+		if epoch != "" {
+			want = 1700000000
+		}
+		if f.TimeDateStamp != want {
+			t.Errorf("SOURCE_DATE_EPOCH=%q: got time stamp %d, want %d", epoch, f.TimeDateStamp, want)
+		}
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	set := NewResourceSet()
+	defer set.Close()
+	if _, err := set.WriteTo(ioutil.Discard, "amd64"); err == nil || !strings.Contains(err.Error(), "SOURCE_DATE_EPOCH") {
+		t.Errorf("expected error about SOURCE_DATE_EPOCH, got: %v", err)
+	}
+	set.SetTimestamp(time.Unix(1, 0))
+	if _, err := set.WriteTo(ioutil.Discard, "amd64"); err != nil {
+		t.Errorf("SetTimestamp should override SOURCE_DATE_EPOCH, got: %v", err)
+	}
+}
+
+func FuzzAddIconFile(f *testing.F) {
+	testutil.AddFiles(f, filepath.Join("..", "testdata", "*.ico"))
+	f.Add(unalignedIcoFile())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		set := NewResourceSet()
+		defer set.Close()
+		set.SetFS(fstest.MapFS{"app.ico": {Data: data}})
+		set.SetTimestamp(time.Unix(1, 0))
+		if err := set.AddIconFile("app.ico"); err != nil {
+			return
+		}
+		buf := &bytes.Buffer{}
+		if _, err := set.WriteTo(buf, "amd64"); err != nil {
+			t.Fatalf("icon accepted, but cannot be written: %v", err)
+		}
+		if problems := coff.Verify(buf.Bytes()); problems != nil {
+			t.Errorf("problems found in output:\n%s", strings.Join(problems, "\n"))
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package rsrc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fileSpec is a path of an input file, optionally preceded by a symbolic
+// name of the resource and followed by its explicit ID, written as:
+//
+//	[SYMBOL=]PATH[@ID]
+//
+// e.g. "IDI_APP=icons/app.ico@101".
+type fileSpec struct {
+	symbol string
+	path   string
+	id     uint16 // 0 if the ID is to be assigned automatically
+}
+
+func parseFileSpec(s string) (fileSpec, error) {
+	spec := fileSpec{path: s}
+	if i := strings.IndexByte(spec.path, '='); i > 0 && isIdentifier(spec.path[:i]) {
+		spec.symbol, spec.path = spec.path[:i], spec.path[i+1:]
+	}
+	if i := strings.LastIndexByte(spec.path, '@'); i >= 0 && isDigits(spec.path[i+1:]) {
+		id, err := strconv.ParseUint(spec.path[i+1:], 10, 16)
+		if err != nil || id == 0 {
+			return spec, fmt.Errorf("rsrc: bad ID in '%s': must be between 1 and 65535", s)
+		}
+		spec.path, spec.id = spec.path[:i], uint16(id)
+	}
+	if spec.path == "" {
+		return spec, fmt.Errorf("rsrc: missing path in '%s'", s)
+	}
+	return spec, nil
+}
+
+// String returns spec in the form accepted by parseFileSpec.
+func (spec fileSpec) String() string {
+	s := spec.path
+	if spec.symbol != "" {
+		s = spec.symbol + "=" + s
+	}
+	if spec.id != 0 {
+		s += "@" + strconv.Itoa(int(spec.id))
+	}
+	return s
+}
+
+func parseFileSpecs(ss []string) ([]fileSpec, error) {
+	specs := make([]fileSpec, 0, len(ss))
+	for _, s := range ss {
+		spec, err := parseFileSpec(s)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// isIdentifier reports whether s is valid as an identifier both in C and Go.
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,45 @@
+package rsrc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFileSpec(t *testing.T) {
+	tests := []struct {
+		in   string
+		want fileSpec
+		err  string
+	}{
+		{in: "app.ico", want: fileSpec{path: "app.ico"}},
+		{in: "icons/app.ico@101", want: fileSpec{path: "icons/app.ico", id: 101}},
+		{in: "IDI_APP=app.ico", want: fileSpec{symbol: "IDI_APP", path: "app.ico"}},
+		{in: "IDI_APP=app.ico@65535", want: fileSpec{symbol: "IDI_APP", path: "app.ico", id: 65535}},
+		// not an identifier, so part of the path
+		{in: "a-b=app.ico", want: fileSpec{path: "a-b=app.ico"}},
+		{in: "1ICON=app.ico", want: fileSpec{path: "1ICON=app.ico"}},
+		// not a number, so part of the path
+		{in: "user@host/app.ico", want: fileSpec{path: "user@host/app.ico"}},
+		{in: "app@2x.png", want: fileSpec{path: "app@2x.png"}},
+		{in: "a@b@7", want: fileSpec{path: "a@b", id: 7}},
+		{in: "app.ico@0", err: "bad ID"},
+		{in: "app.ico@65536", err: "bad ID"},
+		{in: "IDI_APP=@1", err: "missing path"},
+		{in: "", err: "missing path"},
+	}
+	for _, tt := range tests {
+		got, err := parseFileSpec(tt.in)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseFileSpec(%q): expected error containing %q, got: %v", tt.in, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("parseFileSpec(%q): %v", tt.in, err)
+		case got != tt.want:
+			t.Errorf("parseFileSpec(%q) = %+v, want %+v", tt.in, got, tt.want)
+		case got.String() != tt.in:
+			t.Errorf("parseFileSpec(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package rsrc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+// VCSVersion describes the state of sources in a local git repository.
+type VCSVersion struct {
+	Tag     string // nearest tag named like vMAJOR.MINOR.PATCH, or empty if none
+	Commits int    // number of commits since Tag (or since the first commit)
+	Hash    string // abbreviated hash of the current commit
+	Dirty   bool   // whether there are uncommitted changes of tracked files
+	Module  string // module path from go.mod, or empty if not in a module
+}
+
+// ReadVCSVersion describes the git repository containing directory dir, by
+// running 'git describe' locally, and reads the module path from go.mod.
+func ReadVCSVersion(dir string) (*VCSVersion, error) {
+	// tags like v1.2 or v2 would be rejected by Info, so look further back
+	out, err := git(dir, "describe", "--tags", "--long", "--dirty", "--abbrev=7", "--match", "v[0-9]*.[0-9]*.[0-9]*")
+	var v *VCSVersion
+	if err == nil {
+		v, err = parseDescribe(out)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// no matching tags
+		out, err = git(dir, "describe", "--always", "--dirty", "--abbrev=7")
+		if err != nil {
+			return nil, err
+		}
+		count, err := git(dir, "rev-list", "--count", "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		v = &VCSVersion{}
+		v.Hash = strings.TrimSuffix(out, "-dirty")
+		v.Dirty = v.Hash != out
+		v.Commits, err = strconv.Atoi(count)
+		if err != nil {
+			return nil, fmt.Errorf("rsrc: unexpected output of 'git rev-list': %s", count)
+		}
+	}
+	v.Module, err = modulePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("rsrc: error running 'git %s': %s: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parseDescribe parses output of 'git describe --long --dirty', e.g.
+// "v1.2.3-rc.1-5-gabc1234-dirty".
+func parseDescribe(s string) (*VCSVersion, error) {
+	v := &VCSVersion{}
+	rest := strings.TrimSuffix(s, "-dirty")
+	v.Dirty = rest != s
+	bad := fmt.Errorf("rsrc: unexpected output of 'git describe': %s", s)
+	i := strings.LastIndexByte(rest, '-')
+	if i < 0 || !strings.HasPrefix(rest[i+1:], "g") {
+		return nil, bad
+	}
+	j := strings.LastIndexByte(rest[:i], '-')
+	if j <= 0 {
+		return nil, bad
+	}
+	var err error
+	v.Tag, v.Hash = rest[:j], rest[i+2:]
+	v.Commits, err = strconv.Atoi(rest[j+1 : i])
+	if err != nil {
+		return nil, bad
+	}
+	return v, nil
+}
+
+// modulePath returns the path of the module containing dir, from its go.mod.
+func modulePath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	dirs := moduleDirs(dir)
+	f, err := os.Open(filepath.Join(dirs[len(dirs)-1], "go.mod"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// Info returns version information with the version numbers taken from
+// v.Tag, and the number of commits since the tag as the fourth one. The
+// textual versions include the pre-release suffix, the commits and the hash.
+// Pre-release tags are marked with VS_FF_PRERELEASE, and uncommitted changes
+// with VS_FF_PRIVATEBUILD.
+func (v *VCSVersion) Info() (*versioninfo.Info, error) {
+	info := &versioninfo.Info{Strings: map[string]string{}}
+	semver := "0.0.0"
+	if v.Tag != "" {
+		semver = strings.TrimPrefix(v.Tag, "v")
+		if i := strings.IndexByte(semver, '+'); i >= 0 {
+			semver = semver[:i]
+		}
+		core, pre := semver, ""
+		if i := strings.IndexByte(semver, '-'); i >= 0 {
+			core, pre = semver[:i], semver[i+1:]
+		}
+		parts := strings.Split(core, ".")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("rsrc: tag '%s' is not a semantic version vMAJOR.MINOR.PATCH", v.Tag)
+		}
+		for i, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("rsrc: tag '%s' is not a semantic version vMAJOR.MINOR.PATCH, with numbers up to 65535", v.Tag)
+			}
+			info.FileVersion[i] = uint16(n)
+		}
+		if pre != "" {
+			info.FileFlags |= versioninfo.VS_FF_PRERELEASE
+		}
+	}
+	commits := v.Commits
+	if commits > 0xffff {
+		commits = 0xffff
+	}
+	info.FileVersion[3] = uint16(commits)
+	info.ProductVersion = info.FileVersion
+
+	// semver build metadata, e.g. 1.2.3-rc.1+5.gabc1234.dirty
+	var build []string
+	if v.Commits > 0 || v.Tag == "" {
+		build = append(build, strconv.Itoa(v.Commits), "g"+v.Hash)
+	}
+	if v.Dirty {
+		info.FileFlags |= versioninfo.VS_FF_PRIVATEBUILD
+		info.Strings["PrivateBuild"] = "uncommitted changes on top of " + v.Hash
+		build = append(build, "dirty")
+	}
+	text := semver
+	if len(build) > 0 {
+		text += "+" + strings.Join(build, ".")
+	}
+	info.Strings["FileVersion"] = text
+	info.Strings["ProductVersion"] = text
+	if v.Module != "" {
+		info.Strings["ProductName"] = v.Module
+	}
+	return info, nil
+}
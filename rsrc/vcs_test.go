@@ -0,0 +1,138 @@
+package rsrc
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/akavel/rsrc/versioninfo"
+)
+
+func TestParseDescribe(t *testing.T) {
+	cases := []struct {
+		in   string
+		want VCSVersion
+		err  bool
+	}{
+		{in: "v1.2.3-0-gabc1234", want: VCSVersion{Tag: "v1.2.3", Hash: "abc1234"}},
+		{in: "v1.2.3-rc.1-5-gabc1234-dirty", want: VCSVersion{Tag: "v1.2.3-rc.1", Commits: 5, Hash: "abc1234", Dirty: true}},
+		{in: "abc1234", err: true},
+		{in: "v1.2.3-x-gabc1234", err: true},
+	}
+	for _, c := range cases {
+		got, err := parseDescribe(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("parseDescribe(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if !c.err && *got != c.want {
+			t.Errorf("parseDescribe(%q) = %+v, want %+v", c.in, *got, c.want)
+		}
+	}
+}
+
+func TestVCSVersionInfo(t *testing.T) {
+	cases := []struct {
+		v       VCSVersion
+		version versioninfo.Version
+		flags   uint32
+		text    string
+	}{
+		{VCSVersion{Tag: "v1.2.3", Hash: "abc1234"}, versioninfo.Version{1, 2, 3, 0}, 0, "1.2.3"},
+		{VCSVersion{Tag: "v1.2.3", Hash: "abc1234", Dirty: true}, versioninfo.Version{1, 2, 3, 0}, versioninfo.VS_FF_PRIVATEBUILD, "1.2.3+dirty"},
+		{VCSVersion{Tag: "v2.0.0-beta.2+meta", Commits: 7, Hash: "abc1234"}, versioninfo.Version{2, 0, 0, 7}, versioninfo.VS_FF_PRERELEASE, "2.0.0-beta.2+7.gabc1234"},
+		{VCSVersion{Commits: 70000, Hash: "abc1234", Dirty: true}, versioninfo.Version{0, 0, 0, 65535}, versioninfo.VS_FF_PRIVATEBUILD, "0.0.0+70000.gabc1234.dirty"},
+	}
+	for _, c := range cases {
+		info, err := c.v.Info()
+		if err != nil {
+			t.Errorf("%+v: %v", c.v, err)
+			continue
+		}
+		if info.FileVersion != c.version || info.ProductVersion != c.version {
+			t.Errorf("%+v: got versions %v, %v, want %v", c.v, info.FileVersion, info.ProductVersion, c.version)
+		}
+		if info.FileFlags != c.flags {
+			t.Errorf("%+v: got flags 0x%x, want 0x%x", c.v, info.FileFlags, c.flags)
+		}
+		if s := info.Strings["FileVersion"]; s != c.text {
+			t.Errorf("%+v: got FileVersion %q, want %q", c.v, s, c.text)
+		}
+		if _, ok := info.Strings["PrivateBuild"]; ok != c.v.Dirty {
+			t.Errorf("%+v: PrivateBuild string present: %v", c.v, ok)
+		}
+	}
+
+	_, err := (&VCSVersion{Tag: "v1.2"}).Info()
+	if err == nil {
+		t.Errorf("expected error for tag not in semver format")
+	}
+}
+
+func TestReadVCSVersion(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"go.mod":        "module example.com/app\n",
+		"cmd/a/main.go": "package main\n",
+	})
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	run("add", ".")
+	run("commit", "-q", "-m", "first")
+
+	v, err := ReadVCSVersion(filepath.Join(dir, "cmd", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Tag != "" || v.Commits != 1 || v.Hash == "" || v.Dirty || v.Module != "example.com/app" {
+		t.Errorf("without tags, got: %+v", v)
+	}
+
+	run("tag", "v1.4.0-rc.1")
+	run("commit", "-q", "--allow-empty", "-m", "second")
+	writeFiles(t, dir, map[string]string{"cmd/a/main.go": "package main // changed\n"})
+	v, err = ReadVCSVersion(filepath.Join(dir, "cmd", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Tag != "v1.4.0-rc.1" || v.Commits != 1 || !v.Dirty {
+		t.Errorf("with tag, got: %+v", v)
+	}
+	info, err := v.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint32(versioninfo.VS_FF_PRERELEASE | versioninfo.VS_FF_PRIVATEBUILD); info.FileFlags != want || info.FileVersion != (versioninfo.Version{1, 4, 0, 1}) {
+		t.Errorf("got version %v, flags 0x%x", info.FileVersion, info.FileFlags)
+	}
+	if info.Strings["ProductName"] != "example.com/app" {
+		t.Errorf("got ProductName %q", info.Strings["ProductName"])
+	}
+
+	// tags which are not semantic versions are skipped
+	run("tag", "v2")
+	run("tag", "v1.5")
+	v, err = ReadVCSVersion(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Tag != "v1.4.0-rc.1" || v.Commits != 1 {
+		t.Errorf("with non-semver tags, got: %+v", v)
+	}
+}
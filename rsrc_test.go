@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +31,33 @@ func TestBuildSucceeds(t *testing.T) {
 	}, {
 		comment: "manifest & icon",
 		args:    []string{"-manifest", "manifest.xml", "-ico", "akavel.ico"},
+	}, {
+		comment: "accelerators",
+		args:    []string{"-accel", "accel.txt"},
+	}, {
+		comment: "message table",
+		args:    []string{"-mc", "events.mc"},
+	}, {
+		comment: "message table with pinned ID and symbol, and Go message IDs",
+		args:    []string{"-mc", "IDR_EVENTS=events.mc@1", "-mcgo", "events.go", "-goids", "ids.go"},
+	}, {
+		comment: "bitmaps",
+		args:    []string{"-bmp", "toolbar.bmp,splash.png"},
+	}, {
+		comment: "html directory & manifest",
+		args:    []string{"-html", "html", "-manifest", "manifest.xml"},
+	}, {
+		comment: "data directory with index",
+		args:    []string{"-data", ".", "-data-include", "*.ico,html/*", "-data-exclude", "syncthing.*"},
+	}, {
+		comment: "go accessors",
+		args:    []string{"-manifest", "manifest.xml", "-ico", "akavel.ico", "-data", "html", "-accessors", "rsrc_windows.go"},
+	}, {
+		comment: "go & C IDs",
+		args:    []string{"-manifest", "manifest.xml", "-ico", "akavel.ico", "-goids", "ids.go", "-header", "resource.h"},
+	}, {
+		comment: "pinned IDs, out of order",
+		args:    []string{"-manifest", "manifest.xml@1", "-ico", "IDI_MAIN=akavel.ico@200,syncthing.ico@100", "-bmp", "toolbar.bmp", "-idrange", "10-99", "-goids", "ids.go"},
 	}}
 	for _, tt := range tests {
 		t.Run(tt.comment, func(t *testing.T) {
@@ -38,7 +70,10 @@ func TestBuildSucceeds(t *testing.T) {
 			// Compile icon/manifest in testdata/ dir
 			os.Stdout.Write([]byte("-- compiling resource(s)...\n"))
 			defer os.Remove(filepath.Join(dir, name))
-			cmd := exec.Command("go", "run", "../rsrc.go", "-arch", "amd64")
+			for _, generated := range []string{"rsrc_windows.go", "ids.go", "resource.h", "events.go"} {
+				defer os.Remove(filepath.Join(dir, generated))
+			}
+			cmd := exec.Command("go", "run", "..", "-arch", "amd64")
 			cmd.Args = append(cmd.Args, tt.args...)
 			cmd.Dir = dir
 			cmd.Stdout = os.Stdout
@@ -48,11 +83,20 @@ func TestBuildSucceeds(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			// Verify if a .syso file with default name was created
+			// Verify if a .syso file with default name was created, as well
+			// as the other requested files
 			_, err = os.Stat(filepath.Join(dir, name))
 			if err != nil {
 				t.Fatal(err)
 			}
+			for i, arg := range tt.args[1:] {
+				switch tt.args[i] {
+				case "-mcgo", "-goids", "-accessors", "-header":
+					if _, err := os.Stat(filepath.Join(dir, arg)); err != nil {
+						t.Errorf("%s %s: %v", tt.args[i], arg, err)
+					}
+				}
+			}
 
 			defer os.Setenv("GOOS", os.Getenv("GOOS"))
 			defer os.Setenv("GOARCH", os.Getenv("GOARCH"))
@@ -109,3 +153,394 @@ func TestBuildSucceeds(t *testing.T) {
 		})
 	}
 }
+
+func TestOutputStdout(t *testing.T) {
+	cmd := exec.Command("go", "run", "..", "-arch", "386", "-manifest", "manifest.xml", "-o", "-")
+	cmd.Dir = "testdata"
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := pe.NewFile(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Machine != pe.IMAGE_FILE_MACHINE_I386 {
+		t.Errorf("got machine 0x%x, want 386", f.Machine)
+	}
+	if f.Section(".rsrc") == nil {
+		t.Errorf("no .rsrc section in output")
+	}
+	if _, err := os.Stat(filepath.Join("testdata", "-")); err == nil {
+		os.Remove(filepath.Join("testdata", "-"))
+		t.Errorf("output written to a file named '-'")
+	}
+}
+
+func TestMultipleArchs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+	run := func(env []string, args ...string) error {
+		cmd := exec.Command(exe, append([]string{"-manifest", "manifest.xml", "-ico", "akavel.ico"}, args...)...)
+		cmd.Dir = "testdata"
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	machine := func(fname string) uint16 {
+		t.Helper()
+		f, err := pe.Open(filepath.Join(tmp, fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		return f.Machine
+	}
+
+	err = run(nil, "-arch", "386,amd64,arm64", "-o", filepath.Join(tmp, "out_{arch}.syso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for arch, want := range map[string]uint16{
+		"386":   pe.IMAGE_FILE_MACHINE_I386,
+		"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+		"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+	} {
+		if got := machine("out_" + arch + ".syso"); got != want {
+			t.Errorf("%s: got machine 0x%x, want 0x%x", arch, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "out_arm.syso")); err == nil {
+		t.Errorf("unexpected output for arm")
+	}
+
+	err = run(nil, "-arch", "all", "-o", filepath.Join(tmp, "all_{arch}.syso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := machine("all_arm.syso"); got != pe.IMAGE_FILE_MACHINE_ARMNT {
+		t.Errorf("all: got machine 0x%x for arm", got)
+	}
+
+	err = run([]string{"GOARCH=arm64"}, "-o", filepath.Join(tmp, "default_{arch}.syso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := machine("default_arm64.syso"); got != pe.IMAGE_FILE_MACHINE_ARM64 {
+		t.Errorf("default from $GOARCH: got machine 0x%x", got)
+	}
+	// without $GOARCH, e.g. when run directly on any host, amd64 as before
+	err = run([]string{"GOARCH="}, "-o", filepath.Join(tmp, "unset_{arch}.syso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := machine("unset_amd64.syso"); got != pe.IMAGE_FILE_MACHINE_AMD64 {
+		t.Errorf("default without $GOARCH: got machine 0x%x", got)
+	}
+
+	verify := exec.Command(exe, "verify")
+	for _, arch := range allArchs {
+		verify.Args = append(verify.Args, filepath.Join(tmp, "all_"+arch+".syso"))
+	}
+	if out, err := verify.Output(); err != nil || len(out) != 0 {
+		t.Errorf("verify: expected no problems, got: %v\n%s", err, out)
+	}
+	data := mustRead(t, filepath.Join(tmp, "all_amd64.syso"))
+	broken := filepath.Join(tmp, "broken.syso")
+	mustWrite(t, broken, data[:len(data)-8])
+	out, err := exec.Command(exe, "verify", broken).Output()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 || !strings.Contains(string(out), broken+": symbol table at offset") {
+		t.Errorf("verify: expected problem with truncated file, got: %v\n%s", err, out)
+	}
+
+	if err := run(nil, "-arch", "386,amd64", "-o", filepath.Join(tmp, "single.syso")); err == nil {
+		t.Errorf("expected error for multiple archs without {arch} in -o")
+	}
+	if err := run(nil, "-arch", "mips", "-o", filepath.Join(tmp, "mips.syso")); err == nil {
+		t.Errorf("expected error for unknown arch")
+	}
+}
+
+// buildRsrc builds the rsrc command into directory dir.
+func buildRsrc(t *testing.T, dir string) string {
+	t.Helper()
+	exe := filepath.Join(dir, "rsrc.exe")
+	cmd := exec.Command("go", "build", "-o", exe, ".")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return exe
+}
+
+func TestConfigMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":    "module example.com/m\n",
+		"rsrc.json": `{"arch": "386,amd64", "version": {"file-version": "1.2", "strings": {"CompanyName": "Example"}}}`,
+		"cmd/app/rsrc.json": fmt.Sprintf(`{"ico": [%q], "accessors": "rsrc_windows.go"}`,
+			filepath.Join(testdata, "akavel.ico")),
+	}
+	for name, data := range files {
+		mustWrite(t, filepath.Join(tmp, filepath.FromSlash(name)), []byte(data))
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Dir = filepath.Join(tmp, "cmd", "app")
+	cmd.Env = append(os.Environ(), "GOPACKAGE=app")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rsrc_windows_386.syso", "rsrc_windows_amd64.syso", "rsrc_windows.go"} {
+		if _, err := os.Stat(filepath.Join(cmd.Dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	f, err := pe.Open(filepath.Join(cmd.Dir, "rsrc_windows_amd64.syso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := f.Section(".rsrc").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("E\x00x\x00a\x00m\x00p\x00l\x00e\x00")) {
+		t.Errorf("CompanyName from module-level config not found in output")
+	}
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+	run := func(epoch string, args ...string) []byte {
+		t.Helper()
+		cmd := exec.Command(exe, append(args, "-arch", "amd64", "-o", "-")...)
+		cmd.Dir = "testdata"
+		cmd.Env = append(os.Environ(), "SOURCE_DATE_EPOCH="+epoch)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// with pinned IDs, the order of files in lists does not matter
+	a := run("", "-manifest", "manifest.xml", "-ico", "akavel.ico@10", "-bmp", "toolbar.bmp@5,splash.png@6")
+	b := run("", "-bmp", "splash.png@6,toolbar.bmp@5", "-ico", "akavel.ico@10", "-manifest", "manifest.xml")
+	if !bytes.Equal(a, b) {
+		t.Errorf("output differs for lists of files with pinned IDs in different order")
+	}
+	// automatic IDs follow the order of the lists
+	if bytes.Equal(run("", "-bmp", "toolbar.bmp,splash.png"), run("", "-bmp", "splash.png,toolbar.bmp")) {
+		t.Errorf("output is the same for lists of files with automatic IDs in different order")
+	}
+	c := run("1700000000", "-manifest", "manifest.xml", "-ico", "akavel.ico@10", "-bmp", "toolbar.bmp@5,splash.png@6")
+	if bytes.Equal(a, c) {
+		t.Errorf("SOURCE_DATE_EPOCH had no effect on output")
+	}
+	if d := run("1700000000", "-manifest", "manifest.xml", "-ico", "akavel.ico@10", "-bmp", "toolbar.bmp@5,splash.png@6"); !bytes.Equal(c, d) {
+		t.Errorf("output differs between runs with the same SOURCE_DATE_EPOCH")
+	}
+}
+
+func TestCheckMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+	syso := filepath.Join(tmp, "out.syso")
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(exe, append(args, "-arch", "amd64", "-o", syso)...)
+		cmd.Dir = "testdata"
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		err := cmd.Run()
+		return stderr.String(), err
+	}
+
+	if msg, err := run("check", "-manifest", "manifest.xml"); err == nil || !strings.Contains(msg, "out.syso: missing") {
+		t.Errorf("expected error about missing file, got: %v\n%s", err, msg)
+	}
+	if msg, err := run("-manifest", "manifest.xml", "-ico", "akavel.ico"); err != nil {
+		t.Fatalf("%v\n%s", err, msg)
+	}
+	before, err := ioutil.ReadFile(syso)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := run("check", "-manifest", "manifest.xml", "-ico", "akavel.ico"); err != nil {
+		t.Errorf("expected up to date file, got: %v\n%s", err, msg)
+	}
+	msg, err := run("check", "-manifest", "manifest.xml", "-ico", "syncthing.ico")
+	if err == nil {
+		t.Errorf("expected error for file out of date")
+	}
+	for _, s := range []string{"out of date", "RT_ICON 3 differs:", "RT_ICON 5 added:", "RT_GROUP_ICON 2 differs:"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("expected %q in output of check, got:\n%s", s, msg)
+		}
+	}
+	after, err := ioutil.ReadFile(syso)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("check modified the checked file")
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+	generate := func(name string, args ...string) string {
+		fname := filepath.Join(tmp, name)
+		cmd := exec.Command(exe, append(args, "-arch", "amd64", "-o", fname)...)
+		cmd.Dir = "testdata"
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return fname
+	}
+	diff := func(a, b string) (string, int) {
+		cmd := exec.Command(exe, "diff", a, b)
+		stdout := &bytes.Buffer{}
+		cmd.Stdout = stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if exit, ok := err.(*exec.ExitError); ok {
+			return stdout.String(), exit.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return stdout.String(), 0
+	}
+
+	a := generate("a.syso", "-manifest", "manifest.xml", "-ico", "akavel.ico")
+	b := generate("b.syso", "-manifest", "manifest.xml", "-ico", "syncthing.ico")
+	if out, status := diff(a, a); status != 0 || out != "" {
+		t.Errorf("expected no differences, got status %d:\n%s", status, out)
+	}
+	out, status := diff(a, b)
+	if status != 1 {
+		t.Errorf("expected status 1 for different files, got %d", status)
+	}
+	for _, s := range []string{"RT_GROUP_ICON 2 differs:", "  entry 1: ", "RT_ICON 3 differs:", "  image: "} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in output of diff, got:\n%s", s, out)
+		}
+	}
+	if _, status := diff(a, filepath.Join(tmp, "missing.syso")); status != 2 {
+		t.Errorf("expected status 2 for missing file, got %d", status)
+	}
+
+	// Link a.syso into an app, and check that its resources are found in the
+	// PE file.
+	app := filepath.Join(tmp, "app")
+	mustWrite(t, filepath.Join(app, "main.go"), mustRead(t, filepath.Join("testdata", "tmp.go")))
+	mustWrite(t, filepath.Join(app, "go.mod"), []byte("module app\n"))
+	mustWrite(t, filepath.Join(app, "rsrc.syso"), mustRead(t, a))
+	cmd := exec.Command("go", "build", "-o", "app.exe")
+	cmd.Dir = app
+	cmd.Env = append(os.Environ(), "GOOS=windows", "GOARCH=amd64")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out, status := diff(a, filepath.Join(app, "app.exe")); status != 0 || out != "" {
+		t.Errorf("expected resources of linked app to match, got status %d:\n%s", status, out)
+	}
+}
+
+func TestLayoutMode(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "rsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := buildRsrc(t, tmp)
+	syso := filepath.Join(tmp, "out.syso")
+	cmd := exec.Command(exe, "layout", "-manifest", "manifest.xml", "-arch", "386", "-o", syso, "-header", filepath.Join(tmp, "resource.h"))
+	cmd.Dir = "testdata"
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"# " + syso + "\n",
+		"0x00000000      2  /FileHeader/Machine ",
+		"/Dir/Dirs[0]/Dirs[0]/DirEntries[0]/NameOrId",
+		"/Data[0]/Data",
+		"\"<?xml",
+	} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("expected %q in layout, got:\n%s", s, out)
+		}
+	}
+	for _, fname := range []string{syso, filepath.Join(tmp, "resource.h")} {
+		if _, err := os.Stat(fname); !os.IsNotExist(err) {
+			t.Errorf("expected layout mode not to write %s, got: %v", fname, err)
+		}
+	}
+
+	// Existing files are read, also broken ones.
+	cmd = exec.Command(exe, "-manifest", "manifest.xml", "-arch", "386", "-o", syso)
+	cmd.Dir = "testdata"
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := exec.Command(exe, "layout", syso).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// empty directories of leaves, not pointed at by anything, are unused
+	for _, line := range strings.SplitAfter(string(fromFile), "\n") {
+		if !strings.Contains(string(out), line) && !strings.Contains(line, "(unused)") {
+			t.Errorf("expected layout of written file to match, got line %q in:\n%s\nwant:\n%s", line, fromFile, out)
+		}
+	}
+	broken := filepath.Join(tmp, "broken.syso")
+	mustWrite(t, broken, mustRead(t, syso)[:100])
+	fromFile, err = exec.Command(exe, "layout", broken).Output()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+		t.Errorf("expected status 1 for broken file, got: %v", err)
+	}
+	for _, s := range []string{
+		"# " + broken + "\n",
+		"/Dir/DirEntries[0]/NameOrId",
+		"# problem: .rsrc section at offset 0x3c",
+	} {
+		if !strings.Contains(string(fromFile), s) {
+			t.Errorf("expected %q in layout of broken file, got:\n%s", s, fromFile)
+		}
+	}
+}
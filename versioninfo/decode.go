@@ -0,0 +1,144 @@
+package versioninfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Decode parses a VS_VERSIONINFO structure, as stored in a resource. Strings
+// of the first StringTable are returned in Strings, with its language in
+// Lang, and the other tables in Tables. Translations are returned as listed
+// in VarFileInfo.
+func Decode(data []byte) (*Info, error) {
+	root, err := decodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.key != "VS_VERSION_INFO" {
+		return nil, fmt.Errorf("versioninfo: bad key of root block: '%s'", root.key)
+	}
+	var fixed VS_FIXEDFILEINFO
+	if len(root.value) < binary.Size(fixed) {
+		return nil, fmt.Errorf("versioninfo: VS_FIXEDFILEINFO too short: %d bytes", len(root.value))
+	}
+	binary.Read(bytes.NewReader(root.value), binary.LittleEndian, &fixed)
+	if fixed.Signature != VS_FFI_SIGNATURE {
+		return nil, fmt.Errorf("versioninfo: bad signature of VS_FIXEDFILEINFO: 0x%08x", fixed.Signature)
+	}
+	info := &Info{
+		FileVersion:    version(fixed.FileVersionMS, fixed.FileVersionLS),
+		ProductVersion: version(fixed.ProductVersionMS, fixed.ProductVersionLS),
+		FileFlags:      fixed.FileFlags,
+		FileType:       fixed.FileType,
+		Date:           fromFiletime(uint64(fixed.FileDateMS)<<32 | uint64(fixed.FileDateLS)),
+	}
+	for _, child := range root.children {
+		switch child.key {
+		case "StringFileInfo":
+			for _, table := range child.children {
+				t, err := ParseTranslation(table.key)
+				if err != nil || len(table.key) != 8 {
+					return nil, fmt.Errorf("versioninfo: bad key of StringTable: '%s'", table.key)
+				}
+				strs := map[string]string{}
+				for _, s := range table.children {
+					strs[s.key] = decodeText(s.value)
+				}
+				if info.Strings == nil {
+					info.Lang, info.Strings = t.Lang, strs
+				} else {
+					info.Tables = append(info.Tables, StringTable{t, strs})
+				}
+			}
+		case "VarFileInfo":
+			for _, v := range child.children {
+				if v.key != "Translation" {
+					continue
+				}
+				info.Translations = []Translation{}
+				for i := 0; i+4 <= len(v.value); i += 4 {
+					info.Translations = append(info.Translations, Translation{
+						Lang:     binary.LittleEndian.Uint16(v.value[i:]),
+						CodePage: binary.LittleEndian.Uint16(v.value[i+2:]),
+					})
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+func version(ms, ls uint32) Version {
+	return Version{uint16(ms >> 16), uint16(ms), uint16(ls >> 16), uint16(ls)}
+}
+
+// fromFiletime is the reverse of filetime.
+func fromFiletime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const epoch = 11644473600 // seconds from 1601 to 1970
+	return time.Unix(int64(ft/1e7)-epoch, int64(ft%1e7)*100).UTC()
+}
+
+// decodeNode parses a block of VS_VERSIONINFO, with its children. Values of
+// text blocks are returned with the terminating NUL.
+func decodeNode(data []byte) (*node, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("versioninfo: block truncated: %d bytes", len(data))
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	n := &node{text: binary.LittleEndian.Uint16(data[4:]) == 1}
+	if length < 6 || length > len(data) {
+		return nil, fmt.Errorf("versioninfo: bad length of block: %d, with %d bytes available", length, len(data))
+	}
+	data = data[:length]
+	i := 6
+	var key []uint16
+	for ; ; i += 2 {
+		if i+2 > len(data) {
+			return nil, fmt.Errorf("versioninfo: key of block not terminated")
+		}
+		c := binary.LittleEndian.Uint16(data[i:])
+		if c == 0 {
+			break
+		}
+		key = append(key, c)
+	}
+	n.key = string(utf16.Decode(key))
+	i = alignIndex(i + 2)
+	if n.text {
+		valueLength *= 2
+	}
+	if i > len(data) || valueLength > len(data)-i {
+		return nil, fmt.Errorf("versioninfo: value of block '%s' exceeds its length", n.key)
+	}
+	n.value = data[i : i+valueLength]
+	n.valueLength = uint16(valueLength)
+	for i = alignIndex(i + valueLength); i < len(data); {
+		child, err := decodeNode(data[i:])
+		if err != nil {
+			return nil, err
+		}
+		n.children = append(n.children, child)
+		i = alignIndex(i + int(binary.LittleEndian.Uint16(data[i:])))
+	}
+	return n, nil
+}
+
+func alignIndex(i int) int {
+	return (i + 3) &^ 3
+}
+
+func decodeText(value []byte) string {
+	u := make([]uint16, len(value)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(value[2*i:])
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\000")
+}
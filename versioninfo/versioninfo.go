@@ -0,0 +1,355 @@
+// Package versioninfo describes Windows version information resources
+// (RT_VERSION), as shown on the "Details" tab of file properties in Explorer.
+package versioninfo
+
+// VS_VERSIONINFO: https://docs.microsoft.com/en-us/windows/win32/menurc/vs-versioninfo
+// VS_FIXEDFILEINFO: https://docs.microsoft.com/en-us/windows/win32/api/verrsrc/ns-verrsrc-vs_fixedfileinfo
+// StringFileInfo: https://docs.microsoft.com/en-us/windows/win32/menurc/stringfileinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	VS_FFI_SIGNATURE     = 0xFEEF04BD
+	VS_FFI_STRUCVERSION  = 0x00010000
+	VS_FFI_FILEFLAGSMASK = 0x0000003F
+)
+
+// Flags of VS_FIXEDFILEINFO.FileFlags.
+const (
+	VS_FF_DEBUG        = 0x01
+	VS_FF_PRERELEASE   = 0x02
+	VS_FF_PATCHED      = 0x04
+	VS_FF_PRIVATEBUILD = 0x08
+	VS_FF_INFOINFERRED = 0x10
+	VS_FF_SPECIALBUILD = 0x20
+)
+
+// Values of VS_FIXEDFILEINFO.FileOS and FileType.
+const (
+	VOS_NT_WINDOWS32 = 0x00040004
+
+	VFT_UNKNOWN = 0
+	VFT_APP     = 1
+	VFT_DLL     = 2
+)
+
+// CP_UNICODE is the default code page of string tables. Strings are always
+// stored in UTF-16, whatever the code page in the key of their table.
+const CP_UNICODE = 1200
+
+type VS_FIXEDFILEINFO struct {
+	Signature        uint32
+	StrucVersion     uint32
+	FileVersionMS    uint32
+	FileVersionLS    uint32
+	ProductVersionMS uint32
+	ProductVersionLS uint32
+	FileFlagsMask    uint32
+	FileFlags        uint32
+	FileOS           uint32
+	FileType         uint32
+	FileSubtype      uint32
+	FileDateMS       uint32
+	FileDateLS       uint32
+}
+
+// Version is a four-part version number, e.g. {1, 2, 3, 0} for 1.2.3.0.
+type Version [4]uint16
+
+// ParseVersion parses a version written as 1 to 4 dot-separated numbers,
+// e.g. "1.2.3"; missing parts are zero. An optional "v" prefix is allowed.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) > 4 {
+		return v, fmt.Errorf("versioninfo: bad version '%s': too many parts", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return v, fmt.Errorf("versioninfo: bad version '%s': expected up to 4 numbers between 0 and 65535, separated by dots", s)
+		}
+		v[i] = uint16(n)
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v[0], v[1], v[2], v[3])
+}
+
+func (v Version) ms() uint32 { return uint32(v[0])<<16 | uint32(v[1]) }
+func (v Version) ls() uint32 { return uint32(v[2])<<16 | uint32(v[3]) }
+
+// Translation identifies a StringTable by its language and code page, e.g.
+// {0x0409, CP_UNICODE} for U.S. English.
+type Translation struct {
+	Lang     uint16
+	CodePage uint16
+}
+
+// ParseTranslation parses a language ID, optionally followed by a code page,
+// as 4 or 8 hex digits in the style of StringTable keys, e.g. "0415" or
+// "041504b0". The code page defaults to CP_UNICODE.
+func ParseTranslation(s string) (Translation, error) {
+	t := Translation{CodePage: CP_UNICODE}
+	if len(s) != 4 && len(s) != 8 {
+		return t, fmt.Errorf("versioninfo: bad translation '%s': expected 4 or 8 hex digits, e.g. 0409 or 040904b0", s)
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return t, fmt.Errorf("versioninfo: bad translation '%s': expected 4 or 8 hex digits, e.g. 0409 or 040904b0", s)
+	}
+	if len(s) == 4 {
+		t.Lang = uint16(n)
+	} else {
+		t.Lang, t.CodePage = uint16(n>>16), uint16(n)
+	}
+	return t, nil
+}
+
+// String returns the key of a StringTable for t, e.g. "040904b0".
+func (t Translation) String() string {
+	return fmt.Sprintf("%04x%04x", t.Lang, t.CodePage)
+}
+
+// StringTable holds strings translated to one language.
+type StringTable struct {
+	Translation
+	Strings map[string]string
+}
+
+// Info is the contents of a version information resource.
+//
+// Strings are stored in StringFileInfo in the language Lang, with keys such
+// as "CompanyName", "FileDescription", "FileVersion", "InternalName",
+// "LegalCopyright", "OriginalFilename", "ProductName" and "ProductVersion".
+// If Strings lacks "FileVersion" or "ProductVersion", they are filled in
+// from FileVersion and ProductVersion.
+//
+// Tables add strings in other languages; any strings they lack are taken
+// from Strings. Translations lists the languages in VarFileInfo, and defaults
+// to one entry per string table, starting with Lang.
+type Info struct {
+	FileVersion    Version
+	ProductVersion Version
+	FileFlags      uint32    // VS_FF_* flags
+	FileType       uint32    // VFT_APP if zero
+	Lang           uint16    // 0x0409 (U.S. English) if zero
+	Date           time.Time // FileDate; stored as 0 if zero
+	Strings        map[string]string
+	Tables         []StringTable // a zero CodePage means CP_UNICODE
+	Translations   []Translation
+}
+
+func (info *Info) lang() uint16 {
+	if info.Lang == 0 {
+		return 0x0409
+	}
+	return info.Lang
+}
+
+// tables returns all the string tables, starting with the one for Lang, with
+// the default strings filled in.
+func (info *Info) tables() []StringTable {
+	strs := map[string]string{
+		"FileVersion":    info.FileVersion.String(),
+		"ProductVersion": info.ProductVersion.String(),
+	}
+	for k, v := range info.Strings {
+		strs[k] = v
+	}
+	tables := []StringTable{{Translation{info.lang(), CP_UNICODE}, strs}}
+	for _, t := range info.Tables {
+		if t.CodePage == 0 {
+			t.CodePage = CP_UNICODE
+		}
+		merged := map[string]string{}
+		for k, v := range strs {
+			merged[k] = v
+		}
+		for k, v := range t.Strings {
+			merged[k] = v
+		}
+		tables = append(tables, StringTable{t.Translation, merged})
+	}
+	return tables
+}
+
+func (info *Info) translations() []Translation {
+	if info.Translations != nil {
+		return info.Translations
+	}
+	var list []Translation
+	for _, t := range info.tables() {
+		list = append(list, t.Translation)
+	}
+	return list
+}
+
+// Languages returns the languages of all the string tables, without
+// duplicates, starting with Lang.
+func (info *Info) Languages() []uint16 {
+	var langs []uint16
+	seen := map[uint16]bool{}
+	for _, t := range info.tables() {
+		if !seen[t.Lang] {
+			seen[t.Lang] = true
+			langs = append(langs, t.Lang)
+		}
+	}
+	return langs
+}
+
+// Validate returns descriptions of inconsistencies between string tables and
+// Translations, which may make Windows show no strings at all, or strings in
+// an unexpected language.
+func (info *Info) Validate() []string {
+	var problems []string
+	tables := map[Translation]bool{}
+	for _, t := range info.tables() {
+		if tables[t.Translation] {
+			problems = append(problems, fmt.Sprintf("duplicate string table %s", t.Translation))
+		}
+		tables[t.Translation] = true
+	}
+	listed := map[Translation]bool{}
+	for _, t := range info.translations() {
+		if !tables[t] {
+			problems = append(problems, fmt.Sprintf("translation %s has no matching string table", t))
+		}
+		listed[t] = true
+	}
+	for _, t := range info.tables() {
+		if !listed[t.Translation] {
+			problems = append(problems, fmt.Sprintf("string table %s is not listed in translations", t.Translation))
+			listed[t.Translation] = true
+		}
+	}
+	return problems
+}
+
+// Encode returns the VS_VERSIONINFO structure, as stored in a resource.
+func (info *Info) Encode() []byte {
+	return info.EncodeLang(info.lang())
+}
+
+// EncodeLang is like Encode, but moves translations in language lang to the
+// front of the list, for a resource stored in that language. Windows shows
+// strings from the table of the first translation.
+func (info *Info) EncodeLang(lang uint16) []byte {
+	ftype := info.FileType
+	if ftype == 0 {
+		ftype = VFT_APP
+	}
+
+	fixed := VS_FIXEDFILEINFO{
+		Signature:        VS_FFI_SIGNATURE,
+		StrucVersion:     VS_FFI_STRUCVERSION,
+		FileVersionMS:    info.FileVersion.ms(),
+		FileVersionLS:    info.FileVersion.ls(),
+		ProductVersionMS: info.ProductVersion.ms(),
+		ProductVersionLS: info.ProductVersion.ls(),
+		FileFlagsMask:    VS_FFI_FILEFLAGSMASK,
+		FileFlags:        info.FileFlags,
+		FileOS:           VOS_NT_WINDOWS32,
+		FileType:         ftype,
+		FileDateMS:       uint32(filetime(info.Date) >> 32),
+		FileDateLS:       uint32(filetime(info.Date)),
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, fixed)
+	root := &node{key: "VS_VERSION_INFO", value: buf.Bytes(), valueLength: uint16(buf.Len())}
+
+	sfi := &node{key: "StringFileInfo", text: true}
+	for _, t := range info.tables() {
+		keys := make([]string, 0, len(t.Strings))
+		for k := range t.Strings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		table := &node{key: t.Translation.String(), text: true}
+		for _, k := range keys {
+			table.children = append(table.children, stringNode(k, t.Strings[k]))
+		}
+		sfi.children = append(sfi.children, table)
+	}
+	root.children = append(root.children, sfi)
+
+	translations := append([]Translation(nil), info.translations()...)
+	sort.SliceStable(translations, func(i, j int) bool {
+		return translations[i].Lang == lang && translations[j].Lang != lang
+	})
+	buf = &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, translations)
+	root.children = append(root.children, &node{key: "VarFileInfo", text: true, children: []*node{
+		{key: "Translation", value: buf.Bytes(), valueLength: uint16(buf.Len())},
+	}})
+
+	buf = &bytes.Buffer{}
+	root.encode(buf)
+	return buf.Bytes()
+}
+
+// filetime returns t as a FILETIME: the number of 100-nanosecond intervals
+// since January 1, 1601 (UTC), or 0 for the zero time.
+func filetime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	const epoch = 11644473600 // seconds from 1601 to 1970
+	return uint64(t.Unix()+epoch)*1e7 + uint64(t.Nanosecond()/100)
+}
+
+// node is the generic structure of all blocks of VS_VERSIONINFO: a header,
+// a key, a value and children, each aligned to 32 bits.
+type node struct {
+	key         string
+	value       []byte
+	valueLength uint16 // in bytes for binary values, in WCHARs for text
+	text        bool
+	children    []*node
+}
+
+func stringNode(key, value string) *node {
+	u := utf16.Encode([]rune(value + "\000"))
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, u)
+	return &node{key: key, value: buf.Bytes(), valueLength: uint16(len(u)), text: true}
+}
+
+func (n *node) encode(buf *bytes.Buffer) {
+	start := buf.Len()
+	hdr := struct {
+		Length      uint16
+		ValueLength uint16
+		Type        uint16 // 1 for text, 0 for binary
+	}{ValueLength: n.valueLength}
+	if n.text {
+		hdr.Type = 1
+	}
+	binary.Write(buf, binary.LittleEndian, hdr)
+	binary.Write(buf, binary.LittleEndian, utf16.Encode([]rune(n.key+"\000")))
+	align(buf)
+	buf.Write(n.value)
+	for _, child := range n.children {
+		align(buf)
+		child.encode(buf)
+	}
+	binary.LittleEndian.PutUint16(buf.Bytes()[start:], uint16(buf.Len()-start))
+}
+
+// align pads buf with zeros to a multiple of 32 bits; buf must start at an
+// aligned offset.
+func align(buf *bytes.Buffer) {
+	buf.Write(make([]byte, -buf.Len()&3))
+}
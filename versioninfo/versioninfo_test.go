@@ -0,0 +1,271 @@
+package versioninfo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Version
+		err  bool
+	}{
+		{"1", Version{1, 0, 0, 0}, false},
+		{"v1.2.3", Version{1, 2, 3, 0}, false},
+		{"1.2.3.65535", Version{1, 2, 3, 65535}, false},
+		{"1.2.3.4.5", Version{}, true},
+		{"1.x", Version{}, true},
+		{"1.65536", Version{}, true},
+		{"", Version{}, true},
+	}
+	for _, c := range cases {
+		got, err := ParseVersion(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("ParseVersion(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if !c.err && got != c.want {
+			t.Errorf("ParseVersion(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+// block is a decoded node of VS_VERSIONINFO.
+type block struct {
+	key      string
+	typ      uint16
+	value    []byte
+	children []block
+}
+
+func decode(t *testing.T, data []byte) block {
+	t.Helper()
+	length := int(binary.LittleEndian.Uint16(data))
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	b := block{typ: binary.LittleEndian.Uint16(data[4:])}
+	if length > len(data) {
+		t.Fatalf("block length %d exceeds available %d bytes", length, len(data))
+	}
+	data = data[:length]
+	i := 6
+	var key []uint16
+	for ; binary.LittleEndian.Uint16(data[i:]) != 0; i += 2 {
+		key = append(key, binary.LittleEndian.Uint16(data[i:]))
+	}
+	b.key = string(utf16.Decode(key))
+	i = (i + 2 + 3) &^ 3
+	if b.typ == 1 {
+		valueLength *= 2
+	}
+	b.value = data[i : i+valueLength]
+	i = (i + valueLength + 3) &^ 3
+	for i < len(data) {
+		child := decode(t, data[i:])
+		b.children = append(b.children, child)
+		i = (i + int(binary.LittleEndian.Uint16(data[i:])) + 3) &^ 3
+	}
+	return b
+}
+
+func text(value []byte) string {
+	u := make([]uint16, len(value)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(value[2*i:])
+	}
+	return strings.TrimSuffix(string(utf16.Decode(u)), "\000")
+}
+
+func TestEncode(t *testing.T) {
+	info := &Info{
+		FileVersion:    Version{1, 2, 3, 4},
+		ProductVersion: Version{1, 2, 0, 0},
+		FileFlags:      VS_FF_PRERELEASE,
+		Lang:           0x0415,
+		Date:           time.Unix(0, 0),
+		Strings: map[string]string{
+			"CompanyName":    "Zażółć",
+			"ProductVersion": "1.2-beta",
+		},
+	}
+	data := info.Encode()
+	if len(data)%4 != 0 {
+		t.Errorf("length %d not aligned to 32 bits", len(data))
+	}
+	root := decode(t, data)
+	if root.key != "VS_VERSION_INFO" || root.typ != 0 {
+		t.Fatalf("bad root block: %q type %d", root.key, root.typ)
+	}
+
+	var fixed VS_FIXEDFILEINFO
+	if len(root.value) != binary.Size(fixed) {
+		t.Fatalf("got VS_FIXEDFILEINFO of %d bytes, want %d", len(root.value), binary.Size(fixed))
+	}
+	get := func(i int) uint32 { return binary.LittleEndian.Uint32(root.value[4*i:]) }
+	if got := get(0); got != VS_FFI_SIGNATURE {
+		t.Errorf("got signature %08x", got)
+	}
+	if ms, ls := get(2), get(3); ms != 0x00010002 || ls != 0x00030004 {
+		t.Errorf("got file version %08x.%08x", ms, ls)
+	}
+	if flags, ftype := get(7), get(9); flags != VS_FF_PRERELEASE || ftype != VFT_APP {
+		t.Errorf("got flags %x, type %d", flags, ftype)
+	}
+	if ms, ls := get(11), get(12); ms != 0x019DB1DE || ls != 0xD53E8000 {
+		t.Errorf("got file date %08x%08x, want 1970-01-01 as FILETIME", ms, ls)
+	}
+
+	if len(root.children) != 2 {
+		t.Fatalf("got %d children of root, want 2", len(root.children))
+	}
+	sfi, vfi := root.children[0], root.children[1]
+	if sfi.key != "StringFileInfo" || len(sfi.children) != 1 || sfi.children[0].key != "041504b0" {
+		t.Fatalf("bad StringFileInfo: %+v", sfi)
+	}
+	var strs []string
+	for _, s := range sfi.children[0].children {
+		strs = append(strs, s.key+"="+text(s.value))
+	}
+	want := "CompanyName=Zażółć FileVersion=1.2.3.4 ProductVersion=1.2-beta"
+	if got := strings.Join(strs, " "); got != want {
+		t.Errorf("got strings %q, want %q", got, want)
+	}
+	if vfi.key != "VarFileInfo" || len(vfi.children) != 1 || vfi.children[0].key != "Translation" {
+		t.Fatalf("bad VarFileInfo: %+v", vfi)
+	}
+	if got := fmt.Sprintf("% x", vfi.children[0].value); got != "15 04 b0 04" {
+		t.Errorf("got translation %s", got)
+	}
+}
+
+func TestEncodeTables(t *testing.T) {
+	info := &Info{
+		Strings: map[string]string{"CompanyName": "Example", "ProductName": "Tool"},
+		Tables: []StringTable{
+			{Translation{Lang: 0x0415}, map[string]string{"ProductName": "Narzędzie"}},
+			{Translation{0x0407, 1252}, map[string]string{"ProductName": "Werkzeug"}},
+		},
+	}
+	if problems := info.Validate(); problems != nil {
+		t.Errorf("unexpected problems: %q", problems)
+	}
+	if got := fmt.Sprint(info.Languages()); got != "[1033 1045 1031]" {
+		t.Errorf("got languages %s", got)
+	}
+
+	root := decode(t, info.EncodeLang(0x0415))
+	sfi, vfi := root.children[0], root.children[1]
+	var tables []string
+	for _, table := range sfi.children {
+		var strs []string
+		for _, s := range table.children {
+			if s.key == "CompanyName" || s.key == "ProductName" {
+				strs = append(strs, s.key+"="+text(s.value))
+			}
+		}
+		tables = append(tables, table.key+": "+strings.Join(strs, " "))
+	}
+	want := "040904b0: CompanyName=Example ProductName=Tool; " +
+		"041504b0: CompanyName=Example ProductName=Narzędzie; " +
+		"040704e4: CompanyName=Example ProductName=Werkzeug"
+	if got := strings.Join(tables, "; "); got != want {
+		t.Errorf("got string tables:\n%s\nwant:\n%s", got, want)
+	}
+	// translation of the resource's language comes first
+	if got := fmt.Sprintf("% x", vfi.children[0].value); got != "15 04 b0 04 09 04 b0 04 07 04 e4 04" {
+		t.Errorf("got translations %s", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	info := &Info{
+		Lang:         0x0415,
+		Tables:       []StringTable{{Translation{0x0409, CP_UNICODE}, nil}, {Translation{0x0409, CP_UNICODE}, nil}},
+		Translations: []Translation{{0x0415, CP_UNICODE}, {0x0407, CP_UNICODE}},
+	}
+	want := []string{
+		"duplicate string table 040904b0",
+		"translation 040704b0 has no matching string table",
+		"string table 040904b0 is not listed in translations",
+	}
+	if got := info.Validate(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseTranslation(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Translation
+		err  bool
+	}{
+		{"0415", Translation{0x0415, CP_UNICODE}, false},
+		{"040704e4", Translation{0x0407, 1252}, false},
+		{"415", Translation{}, true},
+		{"0x0415", Translation{}, true},
+	}
+	for _, c := range cases {
+		got, err := ParseTranslation(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("ParseTranslation(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if !c.err && got != c.want {
+			t.Errorf("ParseTranslation(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	info := &Info{
+		FileVersion:    Version{1, 2, 3, 4},
+		ProductVersion: Version{1, 2, 0, 0},
+		FileFlags:      VS_FF_PRERELEASE,
+		FileType:       VFT_DLL,
+		Lang:           0x0415,
+		Date:           time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Strings:        map[string]string{"CompanyName": "Zażółć"},
+		Tables:         []StringTable{{Translation{0x0409, CP_UNICODE}, map[string]string{"CompanyName": "Example"}}},
+	}
+	got, err := Decode(info.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := *info
+	want.Strings = map[string]string{"CompanyName": "Zażółć", "FileVersion": "1.2.3.4", "ProductVersion": "1.2.0.0"}
+	want.Tables = []StringTable{{Translation{0x0409, CP_UNICODE}, map[string]string{"CompanyName": "Example", "FileVersion": "1.2.3.4", "ProductVersion": "1.2.0.0"}}}
+	want.Translations = []Translation{{0x0415, CP_UNICODE}, {0x0409, CP_UNICODE}}
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("got:\n%+v\nwant:\n%+v", got, &want)
+	}
+
+	data := info.Encode()
+	for _, n := range []int{0, 5, 40, len(data) - 1} {
+		if _, err := Decode(data[:n]); err == nil {
+			t.Errorf("expected error for data truncated to %d bytes", n)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	info := &Info{
+		FileVersion: Version{1, 2, 3, 4},
+		Strings:     map[string]string{"ProductName": "Test"},
+		Tables:      []StringTable{{Translation{Lang: 0x0415}, map[string]string{"FileDescription": "Test"}}},
+	}
+	f.Add(info.Encode())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if _, err := Decode(info.Encode()); err != nil {
+			t.Errorf("cannot decode encoded %+v: %v", info, err)
+		}
+	})
+}